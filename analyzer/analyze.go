@@ -0,0 +1,1026 @@
+// Package analyzer parses NetSuite exports and quarterly income statements
+// into P&L reports. It holds the code shared by the Vercel functions in api/,
+// which must each be a single file exporting only their handler.
+package analyzer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Transaction represents a NetSuite transaction detail record
+type Transaction struct {
+	Sheet         string
+	Row           int
+	Date          string
+	PostingDate   time.Time
+	Type          string
+	DocNumber     string
+	Name          string
+	Account       string
+	AccountNumber string
+	AccountName   string
+	Department    string
+	Class         string
+	Subsidiary    string
+	Amount        float64
+	Memo          string
+	// Currency is the ISO code of the amount's currency as read, if any, and
+	// OriginalAmount the amount in that currency once Amount is converted
+	Currency       string
+	OriginalAmount float64
+	// SignConvention is the sign convention of the file or sheet the amount was read from
+	SignConvention string
+}
+
+// PLCategory represents a P&L category with subcategories
+type PLCategory struct {
+	Name          string                 `json:"name"`
+	Total         float64                `json:"total"`
+	Headcount     float64                `json:"headcount"`
+	NonHeadcount  float64                `json:"nonHeadcount"`
+	Subcategories map[string]*PLSubcategory `json:"subcategories"`
+}
+
+// PLSubcategory represents a subcategory breakdown
+type PLSubcategory struct {
+	Name         string  `json:"name"`
+	Headcount    float64 `json:"headcount"`
+	NonHeadcount float64 `json:"nonHeadcount"`
+	Total        float64 `json:"total"`
+}
+
+// PLReport represents the complete P&L report
+type PLReport struct {
+	Revenue             float64                   `json:"revenue"`
+	COGS                *PLCategory               `json:"cogs"`
+	GrossProfit         float64                   `json:"grossProfit"`
+	GrossMargin         float64                   `json:"grossMargin"`
+	OpEx                map[string]*PLCategory    `json:"opex"`
+	TotalOpEx           float64                   `json:"totalOpex"`
+	EBITDA              float64                   `json:"ebitda"`
+	Unclassified        []UnclassifiedTransaction `json:"unclassified"`
+	UnclassifiedOmitted int                       `json:"unclassifiedOmitted,omitempty"`
+	Reconciliation      *Reconciliation           `json:"reconciliation"`
+	Periods             []*PeriodReport           `json:"periods,omitempty"`
+	Warnings            []ParseWarning            `json:"warnings,omitempty"`
+	WarningsOmitted     int                       `json:"warningsOmitted,omitempty"`
+	Metadata            *ParseMetadata            `json:"metadata,omitempty"`
+	Budget              *BudgetAnalysis           `json:"budget,omitempty"`
+	Subsidiaries        []*SubsidiaryReport       `json:"subsidiaries,omitempty"`
+	Eliminations        []EliminationTotal        `json:"eliminations,omitempty"`
+	Currency            string                    `json:"currency,omitempty"`
+	Conversions         []CurrencyConversion      `json:"conversions,omitempty"`
+}
+
+// ParseMetadata describes how the uploaded file was read
+type ParseMetadata struct {
+	Sheets         []SheetMetadata   `json:"sheets,omitempty"`
+	HeaderRow      int               `json:"headerRow"`
+	AmountSource   string            `json:"amountSource"`
+	SignConvention string            `json:"signConvention"`
+	Columns        map[string]string `json:"columns,omitempty"`
+}
+
+// readsColumn reports whether a field was read from a column of any sheet
+func (meta *ParseMetadata) readsColumn(field string) bool {
+	if _, ok := meta.Columns[field]; ok {
+		return true
+	}
+	for _, sheet := range meta.Sheets {
+		if _, ok := sheet.Columns[field]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// SheetMetadata identifies a worksheet that was read, the row its header was
+// found on, how its amounts were read and the columns read from it
+type SheetMetadata struct {
+	Name           string            `json:"name"`
+	HeaderRow      int               `json:"headerRow"`
+	AmountSource   string            `json:"amountSource"`
+	SignConvention string            `json:"signConvention"`
+	Columns        map[string]string `json:"columns,omitempty"`
+}
+
+// ParseWarning describes a problem with a single cell of the uploaded file
+type ParseWarning struct {
+	Sheet   string `json:"sheet,omitempty"`
+	Row     int    `json:"row"`
+	Column  string `json:"column"`
+	Value   string `json:"value"`
+	Message string `json:"message"`
+}
+
+// UnclassifiedTransaction is a transaction that matched no P&L bucket
+type UnclassifiedTransaction struct {
+	Date       string  `json:"date"`
+	DocNumber  string  `json:"docNumber"`
+	Name       string  `json:"name"`
+	Account    string  `json:"account"`
+	Department string  `json:"department"`
+	Class      string  `json:"class"`
+	Subsidiary string  `json:"subsidiary,omitempty"`
+	Amount     float64 `json:"amount"`
+	// Currency and OriginalAmount are the amount as read, before conversion
+	Currency       string  `json:"currency,omitempty"`
+	OriginalAmount float64 `json:"originalAmount,omitempty"`
+}
+
+// Reconciliation ties the report back to the uploaded file, using sign-normalized amounts
+type Reconciliation struct {
+	InputCount        int     `json:"inputCount"`
+	InputTotal        float64 `json:"inputTotal"`
+	ClassifiedCount   int     `json:"classifiedCount"`
+	ClassifiedTotal   float64 `json:"classifiedTotal"`
+	UnclassifiedCount int     `json:"unclassifiedCount"`
+	UnclassifiedTotal float64 `json:"unclassifiedTotal"`
+	EliminatedCount   int     `json:"eliminatedCount,omitempty"`
+	EliminatedTotal   float64 `json:"eliminatedTotal,omitempty"`
+	Difference        float64 `json:"difference"`
+}
+
+// AnalyzeUpload parses the uploaded transaction file and aggregates it into a
+// PLReport, converting foreign-currency amounts with the FX rates, if any. CSV
+// files are streamed row by row; Excel workbooks have to be opened in memory.
+func AnalyzeUpload(file io.Reader, filename string, form url.Values, mapping *AccountMapping, rates *FXTable) (*PLReport, error) {
+	// Normalize dates using the caller's format, or auto-detect when none is given
+	dateLayout, err := dateLayoutFromFormat(form.Get("dateFormat"))
+	if err != nil {
+		return nil, requestError("Invalid date format: ", err)
+	}
+
+	periodOpts, err := periodOptionsFromForm(form)
+	if err != nil {
+		return nil, requestError("Invalid period options: ", err)
+	}
+
+	sheetOpts, err := sheetOptionsFromForm(form)
+	if err != nil {
+		return nil, requestError("Invalid sheet options: ", err)
+	}
+
+	columnMap, err := columnMapFromForm(form)
+	if err != nil {
+		return nil, requestError("Invalid column map: ", err)
+	}
+
+	currency, err := currencyFromForm(form, "currency")
+	if err != nil {
+		return nil, requestError("Invalid currency: ", err)
+	}
+	baseCurrency, err := currencyFromForm(form, "baseCurrency")
+	if err != nil {
+		return nil, requestError("Invalid base currency: ", err)
+	}
+
+	convention := strings.ToLower(strings.TrimSpace(form.Get("signConvention")))
+	if convention != "" && convention != signNatural && convention != signDebitPositive {
+		return nil, requestError("", fmt.Errorf("Invalid sign convention: expected natural or debit"))
+	}
+
+	// Determine file type and parse accordingly
+	ext := strings.ToLower(filepath.Ext(filename))
+	var rows transactionSource
+	if ext == ".xlsx" || ext == ".xls" {
+		// Parse Excel
+		excelRows, err := newExcelSource(file, sheetOpts, columnMap)
+		if err != nil {
+			return nil, requestError("Failed to parse Excel: ", err)
+		}
+		rows = excelRows
+	} else if ext == ".csv" {
+		// Parse CSV
+		csvRows, err := newCSVSource(file, columnMap)
+		if err != nil {
+			return nil, requestError("Failed to parse CSV: ", err)
+		}
+		rows = csvRows
+	} else {
+		return nil, requestError("", fmt.Errorf("Unsupported file type. Please upload a CSV or Excel file."))
+	}
+
+	// Each sheet's header decides its default sign convention; the caller may
+	// override them all
+	meta := rows.metadata()
+	if convention != "" {
+		meta.SignConvention = convention
+		for i := range meta.Sheets {
+			meta.Sheets[i].SignConvention = convention
+		}
+	}
+	opts := reportOptions{
+		mapping:           mapping,
+		signConvention:    convention,
+		eliminate:         true,
+		unclassifiedLimit: maxUnclassified,
+	}
+
+	// Generate P&L report; the overall report doubles as the total column and,
+	// for files with a subsidiary column, the consolidated report
+	converter := newCurrencyConverter(currency, baseCurrency, rates)
+	analysis := newAnalysisBuilder(opts, periodOpts, dateLayout, converter, meta.readsColumn("subsidiary"))
+	for {
+		trans, warnings, err := rows.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, requestError("Failed to read file: ", err)
+		}
+		analysis.add(trans, warnings)
+	}
+
+	// Amounts left in another currency cannot be added to the totals
+	if err := analysis.unconvertedError(); err != nil {
+		return nil, err
+	}
+
+	report := analysis.finish()
+	report.Metadata = meta
+	return report, nil
+}
+
+// transactionSource yields parsed transactions one at a time, returning io.EOF when done
+type transactionSource interface {
+	metadata() *ParseMetadata
+	next() (Transaction, []ParseWarning, error)
+}
+
+// csvSource streams transactions from a NetSuite CSV without holding the file in memory
+type csvSource struct {
+	reader *csv.Reader
+	layout *columnLayout
+	meta   *ParseMetadata
+	rowNum int
+}
+
+// newCSVSource reads the header row and prepares to stream the records that follow.
+// columnMap optionally names the source column of individual fields.
+func newCSVSource(r io.Reader, columnMap map[string]string) (*csvSource, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+
+	// Read header
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	// Find column indices
+	layout, err := resolveColumns(header, columnMap)
+	if err != nil {
+		return nil, err
+	}
+	meta := newParseMetadata(layout)
+	meta.HeaderRow = 1
+
+	// The header is row 1
+	return &csvSource{
+		reader: reader,
+		layout: layout,
+		meta:   meta,
+		rowNum: 1,
+	}, nil
+}
+
+func (src *csvSource) metadata() *ParseMetadata {
+	return src.meta
+}
+
+func (src *csvSource) next() (Transaction, []ParseWarning, error) {
+	for {
+		record, err := src.reader.Read()
+		if err == io.EOF {
+			return Transaction{}, nil, io.EOF
+		}
+		if err != nil {
+			return Transaction{}, nil, fmt.Errorf("failed to read record: %w", err)
+		}
+		src.rowNum++
+		if isBlankRecord(record) {
+			continue
+		}
+
+		trans, warnings := parseRecord(record, src.layout, src.rowNum, src.meta)
+		return trans, warnings, nil
+	}
+}
+
+// excelSource reads transactions from one or more worksheets of an Excel workbook
+type excelSource struct {
+	sheets []*excelSheet
+	meta   *ParseMetadata
+	sheet  int
+	row    int
+}
+
+// excelSheet is a worksheet with its own header row
+type excelSheet struct {
+	name      string
+	rows      [][]string
+	headerRow int
+	layout    *columnLayout
+	meta      *ParseMetadata
+}
+
+// newExcelSource opens the workbook and prepares the selected worksheets.
+// columnMap optionally names the source column of individual fields.
+func newExcelSource(r io.Reader, opts sheetOptions, columnMap map[string]string) (*excelSource, error) {
+	f, err := openWorkbook(r)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sheets, err := selectSheets(f, opts, func(rows [][]string) bool {
+		return findHeaderRow(rows, columnMap) >= 0
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	src := &excelSource{}
+	for _, sheet := range sheets {
+		if len(sheet.rows) == 0 {
+			continue
+		}
+
+		// Locate the header below any title block; default to the first row
+		headerRow := findHeaderRow(sheet.rows, columnMap)
+		if headerRow < 0 {
+			headerRow = 0
+		}
+
+		// Find column indices from header
+		layout, err := resolveColumns(sheet.rows[headerRow], columnMap)
+		if err != nil {
+			return nil, fmt.Errorf("sheet %q: %w", sheet.name, err)
+		}
+		src.sheets = append(src.sheets, &excelSheet{
+			name:      sheet.name,
+			rows:      sheet.rows,
+			headerRow: headerRow,
+			layout:    layout,
+			meta:      newParseMetadata(layout),
+		})
+	}
+
+	if len(src.sheets) == 0 {
+		return nil, fmt.Errorf("no data found in Excel file")
+	}
+
+	// The first sheet decides the reported header row. Every sheet keeps the
+	// sign convention of its own header; when they differ the file is "mixed".
+	meta := *src.sheets[0].meta
+	meta.HeaderRow = src.sheets[0].headerRow + 1
+	for _, sheet := range src.sheets {
+		meta.Sheets = append(meta.Sheets, SheetMetadata{
+			Name:           sheet.name,
+			HeaderRow:      sheet.headerRow + 1,
+			AmountSource:   sheet.meta.AmountSource,
+			SignConvention: sheet.meta.SignConvention,
+			Columns:        sheet.meta.Columns,
+		})
+		if sheet.meta.AmountSource != meta.AmountSource {
+			meta.AmountSource = mixedMetadata
+		}
+		if sheet.meta.SignConvention != meta.SignConvention {
+			meta.SignConvention = mixedMetadata
+		}
+	}
+	src.meta = &meta
+	src.row = src.sheets[0].headerRow + 1
+
+	return src, nil
+}
+
+func (src *excelSource) metadata() *ParseMetadata {
+	return src.meta
+}
+
+func (src *excelSource) next() (Transaction, []ParseWarning, error) {
+	for src.sheet < len(src.sheets) {
+		sheet := src.sheets[src.sheet]
+		if src.row >= len(sheet.rows) {
+			// Move on to the next sheet, starting below its header row
+			src.sheet++
+			if src.sheet < len(src.sheets) {
+				src.row = src.sheets[src.sheet].headerRow + 1
+			}
+			continue
+		}
+
+		record := sheet.rows[src.row]
+		rowNum := src.row + 1
+		src.row++
+		if isBlankRecord(record) {
+			continue
+		}
+
+		trans, warnings := parseRecord(record, sheet.layout, rowNum, sheet.meta)
+		trans.Sheet = sheet.name
+		for i := range warnings {
+			warnings[i].Sheet = sheet.name
+		}
+		return trans, warnings, nil
+	}
+	return Transaction{}, nil, io.EOF
+}
+
+// newParseMetadata inspects the header to decide how amounts are read. A signed
+// Amount column is preferred; otherwise amounts come from Debit minus Credit and
+// are treated as debit-positive. The columns that feed each field are recorded.
+func newParseMetadata(layout *columnLayout) *ParseMetadata {
+	meta := &ParseMetadata{AmountSource: "amount", SignConvention: signNatural}
+	if !layout.has("amount") && layout.has("debit", "credit") {
+		meta.AmountSource = "debit/credit"
+		meta.SignConvention = signDebitPositive
+	}
+
+	meta.Columns = make(map[string]string, len(layout.sources))
+	for field, column := range layout.sources {
+		// Only report the amount columns that are actually read
+		if meta.AmountSource == "amount" && (field == "debit" || field == "credit") {
+			continue
+		}
+		meta.Columns[field] = column
+	}
+	return meta
+}
+
+// parseRecord converts a single data row into a Transaction, reporting any cells it could not read
+func parseRecord(record []string, layout *columnLayout, rowNum int, meta *ParseMetadata) (Transaction, []ParseWarning) {
+	var warnings []ParseWarning
+
+	// readAmount parses an amount cell, recording a warning instead of silently
+	// using 0. The first currency symbol or code found sets the currency.
+	var currency string
+	readAmount := func(column string) float64 {
+		raw := layout.field(record, column)
+		amount, symbolCurrency, err := parseMoney(raw)
+		if currency == "" {
+			currency = symbolCurrency
+		}
+		if err != nil {
+			warnings = append(warnings, ParseWarning{
+				Row:     rowNum,
+				Column:  column,
+				Value:   raw,
+				Message: err.Error(),
+			})
+		}
+		return amount
+	}
+
+	// Parse amount
+	var amount float64
+	if meta.AmountSource == "amount" {
+		amount = readAmount("amount")
+	} else {
+		amount = readAmount("debit") - readAmount("credit")
+	}
+
+	trans := Transaction{
+		Row:        rowNum,
+		Date:       layout.field(record, "date"),
+		Type:       layout.field(record, "type"),
+		DocNumber:  layout.field(record, "docNumber"),
+		Name:       layout.field(record, "name"),
+		Account:    layout.field(record, "account"),
+		Department: layout.field(record, "department"),
+		Class:      layout.field(record, "class"),
+		Subsidiary: layout.field(record, "subsidiary"),
+		Amount:     amount,
+		Memo:       layout.field(record, "memo"),
+		// Sheets combined from one workbook may follow different conventions
+		SignConvention: meta.SignConvention,
+	}
+	trans.AccountNumber, trans.AccountName = splitAccount(trans.Account)
+
+	// A Currency column wins over symbols in the amount
+	trans.Currency = currency
+	if raw := layout.field(record, "currency"); raw != "" {
+		if code, ok := normalizeCurrency(raw); ok {
+			trans.Currency = code
+		} else {
+			warnings = append(warnings, ParseWarning{
+				Row:     rowNum,
+				Column:  "currency",
+				Value:   raw,
+				Message: "unrecognized currency",
+			})
+		}
+	}
+
+	if trans.Account == "" {
+		warnings = append(warnings, ParseWarning{
+			Row:     rowNum,
+			Column:  "account",
+			Message: "missing account",
+		})
+	}
+
+	return trans, warnings
+}
+
+// isBlankRecord reports whether every cell in the row is empty
+func isBlankRecord(record []string) bool {
+	for _, cell := range record {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// thousandsPattern matches numbers that use commas only as thousands separators
+var thousandsPattern = regexp.MustCompile(`^-?\d{1,3}(,\d{3})+(\.\d*)?$`)
+
+// parseAmount converts a string to float, handling various formats
+func parseAmount(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	s = strings.ReplaceAll(s, "$", "")
+	s = strings.ReplaceAll(s, "(", "-")
+	s = strings.ReplaceAll(s, ")", "")
+	s = strings.TrimSpace(s)
+	
+	if s == "" {
+		return 0, nil
+	}
+
+	// Reject decimal commas such as "1.234,56" rather than misreading them
+	if strings.Contains(s, ",") {
+		if !thousandsPattern.MatchString(s) {
+			return 0, fmt.Errorf("unsupported number format (commas are only allowed as thousands separators)")
+		}
+		s = strings.ReplaceAll(s, ",", "")
+	}
+	
+	amount, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return 0, fmt.Errorf("not a number")
+	}
+	return amount, nil
+}
+
+// Sign conventions of the source amounts
+const (
+	// signNatural means revenue and expenses are both positive
+	signNatural = "natural"
+	// signDebitPositive means debits are positive and credits negative, so revenue arrives negative
+	signDebitPositive = "debit"
+)
+
+// mixedMetadata describes combined sheets that read their amounts differently
+const mixedMetadata = "mixed"
+
+// reportOptions holds the per-request settings used to build a PLReport
+type reportOptions struct {
+	mapping *AccountMapping
+	// signConvention, when set, overrides the convention each transaction was read with
+	signConvention string
+	// eliminate removes the mapping's intercompany eliminations, as the consolidated report does
+	eliminate bool
+	// unclassifiedLimit caps the unclassified transactions listed in the
+	// report; the rest only count towards the reconciliation
+	unclassifiedLimit int
+}
+
+// plBuilder aggregates transactions into a PLReport one at a time, so memory
+// does not grow with the number of transactions
+type plBuilder struct {
+	opts   reportOptions
+	report *PLReport
+}
+
+// newPLBuilder starts an empty report with the default OpEx categories
+func newPLBuilder(opts reportOptions) *plBuilder {
+	report := &PLReport{
+		COGS: &PLCategory{
+			Name:          "COGS",
+			Subcategories: make(map[string]*PLSubcategory),
+		},
+		OpEx:           make(map[string]*PLCategory),
+		Unclassified:   []UnclassifiedTransaction{},
+		Reconciliation: &Reconciliation{},
+	}
+
+	// Initialize OpEx categories
+	categories := []string{"G&A", "R&D", "S&M"}
+	for _, cat := range categories {
+		report.OpEx[cat] = &PLCategory{
+			Name:          cat,
+			Subcategories: make(map[string]*PLSubcategory),
+		}
+	}
+
+	return &plBuilder{opts: opts, report: report}
+}
+
+// add adds a classified transaction to the report
+func (b *plBuilder) add(trans *Transaction, c Classification) {
+	report := b.report
+
+	// Revenue is credited, so debit-positive sources report it as negative
+	convention := trans.SignConvention
+	if b.opts.signConvention != "" {
+		convention = b.opts.signConvention
+	}
+	amount := trans.Amount
+	if c.Bucket == bucketRevenue && convention == signDebitPositive {
+		amount = -amount
+	}
+
+	report.Reconciliation.InputCount++
+	report.Reconciliation.InputTotal += amount
+	if c.Bucket == "" {
+		report.Reconciliation.UnclassifiedCount++
+		report.Reconciliation.UnclassifiedTotal += amount
+		if len(report.Unclassified) >= b.opts.unclassifiedLimit {
+			report.UnclassifiedOmitted++
+			return
+		}
+		// Keep unmatched transactions visible so totals tie back to the file
+		report.Unclassified = append(report.Unclassified, UnclassifiedTransaction{
+			Date:       trans.Date,
+			DocNumber:  trans.DocNumber,
+			Name:       trans.Name,
+			Account:    trans.Account,
+			Department: trans.Department,
+			Class:      trans.Class,
+			Subsidiary: trans.Subsidiary,
+			Amount:     trans.Amount,
+			Currency:   trans.Currency,
+			// Only converted transactions have an original amount
+			OriginalAmount: trans.OriginalAmount,
+		})
+		return
+	}
+	if b.opts.eliminate {
+		if rule := b.opts.mapping.elimination(*trans); rule != nil {
+			b.eliminate(rule, c, amount)
+			return
+		}
+	}
+	report.Reconciliation.ClassifiedCount++
+	report.Reconciliation.ClassifiedTotal += amount
+
+	// Categorize transaction
+	switch c.Bucket {
+	case bucketRevenue:
+		report.Revenue += amount
+	case bucketCOGS:
+		addToCategory(report.COGS, c.Subcategory, amount, c.Headcount)
+	case bucketOpEx:
+		cat, ok := report.OpEx[c.Category]
+		if !ok {
+			// Mapping files may introduce OpEx categories beyond the defaults
+			cat = &PLCategory{
+				Name:          c.Category,
+				Subcategories: make(map[string]*PLSubcategory),
+			}
+			report.OpEx[c.Category] = cat
+		}
+		addToCategory(cat, c.Subcategory, amount, c.Headcount)
+	}
+}
+
+// eliminate leaves an intercompany transaction out of the report, adding it to
+// the total of the rule that matched it
+func (b *plBuilder) eliminate(rule *EliminationRule, c Classification, amount float64) {
+	report := b.report
+	report.Reconciliation.EliminatedCount++
+	report.Reconciliation.EliminatedTotal += amount
+
+	var total *EliminationTotal
+	for i := range report.Eliminations {
+		if report.Eliminations[i].Rule == rule.Name {
+			total = &report.Eliminations[i]
+			break
+		}
+	}
+	if total == nil {
+		report.Eliminations = append(report.Eliminations, EliminationTotal{Rule: rule.Name})
+		total = &report.Eliminations[len(report.Eliminations)-1]
+	}
+
+	total.Count++
+	if c.Bucket == bucketRevenue {
+		total.Revenue += amount
+	} else {
+		total.Expenses += amount
+	}
+}
+
+// finish calculates totals and margins and returns the completed report
+func (b *plBuilder) finish() *PLReport {
+	report := b.report
+
+	// Calculate totals
+	calculateCategoryTotals(report.COGS)
+	for _, cat := range report.OpEx {
+		calculateCategoryTotals(cat)
+		report.TotalOpEx += cat.Total
+	}
+
+	report.GrossProfit = report.Revenue - report.COGS.Total
+	if report.Revenue != 0 {
+		report.GrossMargin = (report.GrossProfit / report.Revenue) * 100
+	}
+	report.EBITDA = report.GrossProfit - report.TotalOpEx
+	report.Reconciliation.Difference = report.Reconciliation.InputTotal - report.Reconciliation.ClassifiedTotal
+
+	return report
+}
+
+// maxWarnings caps the warnings kept for a single upload so a badly formatted
+// file cannot grow the response without bound
+const maxWarnings = 1000
+
+// maxUnclassified caps the unclassified transactions listed in the total
+// report, for the same reason
+const maxUnclassified = 1000
+
+// analysisBuilder feeds parsed rows into the total report and, when requested,
+// the per-period reports, collecting row warnings along the way
+type analysisBuilder struct {
+	mapping         *AccountMapping
+	dateLayout      string
+	currency        *currencyConverter
+	total           *plBuilder
+	periods         *periodBuilder
+	subsidiaries    *subsidiaryBuilder
+	warnings        []ParseWarning
+	omittedWarnings int
+	// unconverted lists the transactions left out because they could not be
+	// converted into the reporting currency
+	unconverted        []ParseWarning
+	omittedUnconverted int
+}
+
+// newAnalysisBuilder prepares the builders needed for the requested report
+func newAnalysisBuilder(opts reportOptions, periodOpts periodOptions, dateLayout string, currency *currencyConverter, bySubsidiary bool) *analysisBuilder {
+	a := &analysisBuilder{
+		mapping:    opts.mapping,
+		dateLayout: dateLayout,
+		currency:   currency,
+		total:      newPLBuilder(opts),
+	}
+	if periodOpts.granularity != "" {
+		a.periods = newPeriodBuilder(opts, periodOpts)
+	}
+	if bySubsidiary {
+		a.subsidiaries = newSubsidiaryBuilder(opts)
+	}
+	return a
+}
+
+// add normalizes the transaction's date, converts it into the reporting
+// currency and aggregates it. The transaction is classified once and shared
+// by every builder.
+func (a *analysisBuilder) add(trans Transaction, warnings []ParseWarning) {
+	if warning := normalizeDate(&trans, a.dateLayout); warning != nil {
+		warnings = append(warnings, *warning)
+	}
+	for _, warning := range warnings {
+		if len(a.warnings) < maxWarnings {
+			a.warnings = append(a.warnings, warning)
+		} else {
+			a.omittedWarnings++
+		}
+	}
+	if warning := a.currency.convert(&trans); warning != nil {
+		if len(a.unconverted) < maxWarnings {
+			a.unconverted = append(a.unconverted, *warning)
+		} else {
+			a.omittedUnconverted++
+		}
+		return
+	}
+
+	c := a.mapping.classify(trans)
+	a.total.add(&trans, c)
+	if a.periods != nil {
+		a.periods.add(&trans, c)
+	}
+	if a.subsidiaries != nil {
+		a.subsidiaries.add(&trans, c)
+	}
+}
+
+// unconvertedError rejects the upload when any transaction could not be
+// converted into the reporting currency, listing those transactions
+func (a *analysisBuilder) unconvertedError() error {
+	count := len(a.unconverted) + a.omittedUnconverted
+	if count == 0 {
+		return nil
+	}
+	return &uploadValidationError{
+		message:  fmt.Sprintf("%d transaction(s) could not be converted to %s", count, a.currency.currency),
+		warnings: a.unconverted,
+	}
+}
+
+// finish completes the total report and attaches periods and warnings
+func (a *analysisBuilder) finish() *PLReport {
+	report := a.total.finish()
+	if a.periods != nil {
+		report.Periods = a.periods.finish()
+	}
+	if a.subsidiaries != nil {
+		report.Subsidiaries = a.subsidiaries.finish()
+	}
+
+	report.Currency = a.currency.currency
+	report.Conversions = a.currency.finish()
+	report.Warnings = a.warnings
+	report.WarningsOmitted = a.omittedWarnings
+	return report
+}
+
+// isRevenue checks if account is revenue
+func isRevenue(account string) bool {
+	revenueKeywords := []string{"revenue", "sales", "income"}
+	for _, kw := range revenueKeywords {
+		if strings.Contains(account, kw) && !strings.Contains(account, "deferred") {
+			return true
+		}
+	}
+	return false
+}
+
+// isCOGS checks if transaction is COGS
+func isCOGS(account, dept string) bool {
+	cogsKeywords := []string{"cogs", "cost of goods", "cost of sales", "cost of revenue"}
+	text := account + " " + dept
+	for _, kw := range cogsKeywords {
+		if strings.Contains(text, kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// determineOpExCategory determines which OpEx bucket
+func determineOpExCategory(account, dept, class string) string {
+	text := strings.ToLower(account + " " + dept + " " + class)
+
+	// S&M patterns
+	smKeywords := []string{"sales", "marketing", "sales & marketing", "s&m", "customer success", 
+		"customer support", "sdr", "ae", "account executive"}
+	for _, kw := range smKeywords {
+		if strings.Contains(text, kw) {
+			return "S&M"
+		}
+	}
+
+	// R&D patterns
+	rdKeywords := []string{"r&d", "research", "development", "engineering", "product"}
+	for _, kw := range rdKeywords {
+		if strings.Contains(text, kw) {
+			return "R&D"
+		}
+	}
+
+	// G&A patterns (catch-all for administrative)
+	gaKeywords := []string{"g&a", "general", "administrative", "finance", "accounting", 
+		"legal", "hr", "human resources", "facilities"}
+	for _, kw := range gaKeywords {
+		if strings.Contains(text, kw) {
+			return "G&A"
+		}
+	}
+
+	// Default to G&A for expense accounts
+	if strings.Contains(account, "expense") || strings.Contains(account, "payroll") {
+		return "G&A"
+	}
+
+	return ""
+}
+
+// determineCOGSSubcategory determines COGS subcategory
+func determineCOGSSubcategory(dept, class, account string) string {
+	text := strings.ToLower(dept + " " + class + " " + account)
+
+	if strings.Contains(text, "support") || strings.Contains(text, "customer success") {
+		return "Customer Support"
+	}
+	if strings.Contains(text, "services") || strings.Contains(text, "professional services") {
+		return "Professional Services"
+	}
+	if strings.Contains(text, "hosting") || strings.Contains(text, "infrastructure") {
+		return "Infrastructure"
+	}
+
+	return "Other COGS"
+}
+
+// determineSubcategory determines subcategory for OpEx
+func determineSubcategory(category, dept, class, account string) string {
+	text := strings.ToLower(dept + " " + class + " " + account)
+
+	if category == "S&M" {
+		if strings.Contains(text, "sdr") {
+			return "SDRs"
+		}
+		if strings.Contains(text, "ae") || strings.Contains(text, "account executive") {
+			return "AEs"
+		}
+		if strings.Contains(text, "marketing") {
+			return "Marketing"
+		}
+		if strings.Contains(text, "customer success") || strings.Contains(text, "support") {
+			return "Customer Support"
+		}
+		return "Other S&M"
+	}
+
+	if category == "R&D" {
+		if strings.Contains(text, "engineering") {
+			return "Engineering"
+		}
+		if strings.Contains(text, "product") {
+			return "Product"
+		}
+		return "Other R&D"
+	}
+
+	if category == "G&A" {
+		if strings.Contains(text, "finance") || strings.Contains(text, "accounting") {
+			return "Finance & Accounting"
+		}
+		if strings.Contains(text, "legal") {
+			return "Legal"
+		}
+		if strings.Contains(text, "hr") || strings.Contains(text, "human resources") {
+			return "HR"
+		}
+		if strings.Contains(text, "facilities") {
+			return "Facilities"
+		}
+		return "Other G&A"
+	}
+
+	return "Other"
+}
+
+// isHeadcountCost determines if expense is headcount-related
+func isHeadcountCost(account, memo string) bool {
+	headcountKeywords := []string{
+		"salary", "salaries", "wages", "payroll", "compensation",
+		"benefits", "bonus", "commission", "stock", "equity",
+		"401k", "insurance", "health", "dental", "vision",
+		"pto", "vacation", "severance", "recruiting", "recruitment",
+	}
+
+	text := account + " " + memo
+	for _, kw := range headcountKeywords {
+		if strings.Contains(text, kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// addToCategory adds amount to category and subcategory
+func addToCategory(cat *PLCategory, subcatName string, amount float64, isHeadcount bool) {
+	if isHeadcount {
+		cat.Headcount += amount
+	} else {
+		cat.NonHeadcount += amount
+	}
+
+	// Add to subcategory
+	if cat.Subcategories[subcatName] == nil {
+		cat.Subcategories[subcatName] = &PLSubcategory{Name: subcatName}
+	}
+
+	subcat := cat.Subcategories[subcatName]
+	if isHeadcount {
+		subcat.Headcount += amount
+	} else {
+		subcat.NonHeadcount += amount
+	}
+}
+
+// calculateCategoryTotals calculates totals for category and subcategories
+func calculateCategoryTotals(cat *PLCategory) {
+	cat.Total = cat.Headcount + cat.NonHeadcount
+
+	for _, subcat := range cat.Subcategories {
+		subcat.Total = subcat.Headcount + subcat.NonHeadcount
+	}
+}
@@ -0,0 +1,70 @@
+package analyzer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"runtime"
+	"testing"
+)
+
+// generatedAccounts cycles through revenue, COGS, OpEx and unclassified rows
+var generatedAccounts = []string{
+	"4000 - Revenue",
+	"5100 - COGS - Infrastructure",
+	"6100 - Salaries",
+	"7200 - Software",
+	"8300 - Advertising",
+	"Suspense",
+}
+
+// generateCSV streams a NetSuite export of the given number of rows without
+// holding it in memory
+func generateCSV(rows int) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		w := bufio.NewWriter(pw)
+		fmt.Fprintln(w, "Date,Type,Document Number,Name,Account,Department,Class,Subsidiary,Amount,Memo")
+		for i := 0; i < rows; i++ {
+			fmt.Fprintf(w, "2024-%02d-%02d,Journal,JE-%d,Vendor %d,%s,Engineering,Product A,Sub %d,%d.50,Row %d\n",
+				i%12+1, i%28+1, i, i%100, generatedAccounts[i%len(generatedAccounts)], i%3, i%5000, i)
+		}
+		pw.CloseWithError(w.Flush())
+	}()
+	return pr
+}
+
+// BenchmarkAnalyzeUpload streams generated CSVs of increasing size through
+// AnalyzeUpload. The live-B/op metric is the heap still in use with the report
+// held, which stays flat as the row count grows.
+func BenchmarkAnalyzeUpload(b *testing.B) {
+	form := url.Values{"period": {"month"}}
+	for _, rows := range []int{10000, 100000, 500000} {
+		b.Run(fmt.Sprintf("rows=%d", rows), func(b *testing.B) {
+			b.ReportAllocs()
+			var live uint64
+			for i := 0; i < b.N; i++ {
+				report, err := AnalyzeUpload(generateCSV(rows), "export.csv", form, nil, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if got := report.Reconciliation.InputCount; got != rows {
+					b.Fatalf("read %d rows, want %d", got, rows)
+				}
+				if len(report.Unclassified) > maxUnclassified {
+					b.Fatalf("listed %d unclassified transactions, want at most %d", len(report.Unclassified), maxUnclassified)
+				}
+
+				b.StopTimer()
+				var stats runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&stats)
+				live += stats.HeapAlloc
+				runtime.KeepAlive(report)
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(live)/float64(b.N), "live-B/op")
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package analyzer
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// defaultBudgetThreshold is the variance, in percent of budget, above which a
+// line is flagged when "budgetThreshold" is not set
+const defaultBudgetThreshold = 10
+
+// budgetColumnAliases lists the headers recognized for each budget field
+var budgetColumnAliases = map[string][]string{
+	"category":     {"category", "department"},
+	"subcategory":  {"subcategory", "sub-category", "sub category", "line item"},
+	"headcount":    {"headcount", "hc"},
+	"nonHeadcount": {"non-headcount", "non headcount", "nonheadcount", "non-hc", "non hc"},
+	"total":        {"total", "budget", "amount"},
+}
+
+// budgetCategoryAliases maps common spellings of the analyzer's categories to
+// the names it reports them under
+var budgetCategoryAliases = map[string]string{
+	"revenue":                    "Revenue",
+	"revenues":                   "Revenue",
+	"cogs":                       "COGS",
+	"cost of goods sold":         "COGS",
+	"cost of revenue":            "COGS",
+	"cost of sales":              "COGS",
+	"g&a":                        "G&A",
+	"general & administrative":   "G&A",
+	"general and administrative": "G&A",
+	"r&d":                        "R&D",
+	"research & development":     "R&D",
+	"research and development":   "R&D",
+	"s&m":                        "S&M",
+	"sales & marketing":          "S&M",
+	"sales and marketing":        "S&M",
+}
+
+// BudgetAnalysis lines the budget up against the actuals of the report. Lines
+// whose variance exceeds the threshold are marked OverThreshold.
+type BudgetAnalysis struct {
+	Threshold       float64      `json:"threshold"`
+	ThresholdAmount float64      `json:"thresholdAmount,omitempty"`
+	Lines           []BudgetLine `json:"lines"`
+	OverThreshold   int          `json:"overThreshold"`
+}
+
+// BudgetLine is a report line with its actual and budgeted values. Variance is
+// actual minus budget and VariancePercent is relative to the budget, null when
+// nothing was budgeted; margins vary in percentage points.
+type BudgetLine struct {
+	Section         string   `json:"section,omitempty"`
+	Label           string   `json:"label"`
+	Depth           int      `json:"depth,omitempty"`
+	Kind            string   `json:"kind,omitempty"`
+	Actual          float64  `json:"actual"`
+	Budget          float64  `json:"budget"`
+	Variance        float64  `json:"variance"`
+	VariancePercent *float64 `json:"variancePercent"`
+	Flag            string   `json:"flag,omitempty"`
+	OverThreshold   bool     `json:"overThreshold,omitempty"`
+}
+
+// BudgetOptions sets when a budget variance is flagged
+type BudgetOptions struct {
+	// threshold is the variance in percent of budget, or in points for margins
+	threshold float64
+	// thresholdAmount is the smallest absolute variance that is flagged
+	thresholdAmount float64
+}
+
+// BudgetOptionsFromForm reads the "budgetThreshold" and "budgetThresholdAmount" form fields
+func BudgetOptionsFromForm(form url.Values) (BudgetOptions, error) {
+	opts := BudgetOptions{threshold: defaultBudgetThreshold}
+
+	for _, field := range []struct {
+		name  string
+		value *float64
+	}{
+		{"budgetThreshold", &opts.threshold},
+		{"budgetThresholdAmount", &opts.thresholdAmount},
+	} {
+		value := strings.TrimSpace(form.Get(field.name))
+		if value == "" {
+			continue
+		}
+		number, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || number < 0 || math.IsNaN(number) || math.IsInf(number, 0) {
+			return opts, fmt.Errorf("%s must be a number of at least 0", field.name)
+		}
+		*field.value = number
+	}
+	return opts, nil
+}
+
+// exceeds reports whether a line's variance is over the threshold. Lines with
+// nothing budgeted are over it as soon as they have any actuals.
+func (opts BudgetOptions) exceeds(line VarianceLine) bool {
+	variance := math.Abs(line.Variance)
+	if variance <= balanceTolerance {
+		return false
+	}
+	if line.Kind == lineKindMargin {
+		return variance > opts.threshold
+	}
+	if variance < opts.thresholdAmount {
+		return false
+	}
+	if line.VariancePercent == nil {
+		return true
+	}
+	return math.Abs(*line.VariancePercent) > opts.threshold
+}
+
+// CompareBudget lines the budget up against the report, line for line as in
+// a period comparison, and flags the lines over the threshold
+func CompareBudget(actual, budget *PLReport, opts BudgetOptions) *BudgetAnalysis {
+	analysis := &BudgetAnalysis{
+		Threshold:       opts.threshold,
+		ThresholdAmount: opts.thresholdAmount,
+		Lines:           []BudgetLine{},
+	}
+	for _, line := range ComparePLReports(actual, budget) {
+		budgetLine := BudgetLine{
+			Section:         line.Section,
+			Label:           line.Label,
+			Depth:           line.Depth,
+			Kind:            line.Kind,
+			Actual:          line.Current,
+			Budget:          line.Prior,
+			Variance:        line.Variance,
+			VariancePercent: line.VariancePercent,
+			Flag:            line.Flag,
+			OverThreshold:   opts.exceeds(line),
+		}
+		if budgetLine.OverThreshold {
+			analysis.OverThreshold++
+		}
+		analysis.Lines = append(analysis.Lines, budgetLine)
+	}
+	return analysis
+}
+
+// ParseBudget reads a budget spreadsheet (CSV or Excel) into a PLReport with
+// the same categories and subcategories the analyzer reports. Each row
+// budgets a category and subcategory, split into headcount and non-headcount
+// amounts; a row with only a total is budgeted as non-headcount. Revenue rows
+// only need a total.
+func ParseBudget(data []byte, filename string) (*PLReport, error) {
+	rows, err := readTable(data, filename, func(rows [][]string) bool {
+		return findBudgetHeaderRow(rows) >= 0
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	headerRow := findBudgetHeaderRow(rows)
+	if headerRow < 0 {
+		return nil, fmt.Errorf("no budget header found (expected a Category column and Headcount, Non-Headcount or Total columns)")
+	}
+	layout := resolveAliases(rows[headerRow], budgetColumnAliases)
+
+	builder := newPLBuilder(reportOptions{})
+	for i := headerRow + 1; i < len(rows); i++ {
+		record := rows[i]
+		if isBlankRecord(record) {
+			continue
+		}
+		if err := addBudgetRow(builder.report, record, layout); err != nil {
+			return nil, fmt.Errorf("row %d: %w", i+1, err)
+		}
+	}
+	return builder.finish(), nil
+}
+
+// findBudgetHeaderRow returns the index of the first row among the first
+// headerScanRows that names a category and an amount column, or -1
+func findBudgetHeaderRow(rows [][]string) int {
+	for i := 0; i < headerScanRows && i < len(rows); i++ {
+		layout := resolveAliases(rows[i], budgetColumnAliases)
+		if layout.has("category") && layout.has("headcount", "nonHeadcount", "total") {
+			return i
+		}
+	}
+	return -1
+}
+
+// addBudgetRow adds one budget line to the report. Total rows, such as
+// "Total G&A", are left out since the report computes its own totals.
+func addBudgetRow(report *PLReport, record []string, layout *columnLayout) error {
+	category := budgetCategory(layout.field(record, "category"))
+	subcategory := strings.Join(strings.Fields(layout.field(record, "subcategory")), " ")
+	if category == "" {
+		return fmt.Errorf("missing category")
+	}
+	if isTotalHeader(category) || isTotalHeader(subcategory) {
+		return nil
+	}
+
+	amounts := make(map[string]float64)
+	split := false
+	for _, field := range []string{"headcount", "nonHeadcount", "total"} {
+		raw := layout.field(record, field)
+		amount, err := parseAmount(raw)
+		if err != nil {
+			return fmt.Errorf("column %q: %v", layout.sources[field], err)
+		}
+		amounts[field] = amount
+		if raw != "" && field != "total" {
+			split = true
+		}
+	}
+
+	headcount, nonHeadcount := amounts["headcount"], amounts["nonHeadcount"]
+	if !split {
+		nonHeadcount = amounts["total"]
+	} else if layout.field(record, "total") != "" && math.Abs(headcount+nonHeadcount-amounts["total"]) > balanceTolerance {
+		return fmt.Errorf("total %.2f does not equal headcount plus non-headcount (%.2f)", amounts["total"], headcount+nonHeadcount)
+	}
+
+	switch category {
+	case "Revenue":
+		report.Revenue += headcount + nonHeadcount
+		return nil
+	case "COGS":
+		if subcategory == "" {
+			subcategory = "Other COGS"
+		}
+		addBudgetAmounts(report.COGS, subcategory, headcount, nonHeadcount)
+		return nil
+	}
+
+	cat, ok := report.OpEx[category]
+	if !ok {
+		cat = &PLCategory{
+			Name:          category,
+			Subcategories: make(map[string]*PLSubcategory),
+		}
+		report.OpEx[category] = cat
+	}
+	if subcategory == "" {
+		subcategory = "Other " + category
+	}
+	addBudgetAmounts(cat, subcategory, headcount, nonHeadcount)
+	return nil
+}
+
+// addBudgetAmounts adds a budget line's headcount and non-headcount amounts to a category
+func addBudgetAmounts(cat *PLCategory, subcategory string, headcount, nonHeadcount float64) {
+	addToCategory(cat, subcategory, headcount, true)
+	addToCategory(cat, subcategory, nonHeadcount, false)
+}
+
+// budgetCategory returns the name the analyzer reports a budget category under;
+// other categories keep their own name, as a mapping file may introduce them
+func budgetCategory(name string) string {
+	name = strings.Join(strings.Fields(name), " ")
+	if canonical, ok := budgetCategoryAliases[strings.ToLower(name)]; ok {
+		return canonical
+	}
+	return name
+}
@@ -0,0 +1,192 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// columnAliases lists the headers recognized for each transaction field, in order
+// of preference. Exports carry either a signed Amount column or a Debit/Credit pair.
+var columnAliases = map[string][]string{
+	"date":       {"date", "transaction date"},
+	"type":       {"type", "transaction type"},
+	"docNumber":  {"document number", "doc number", "number"},
+	"name":       {"name", "vendor", "employee", "customer"},
+	"account":    {"account", "account name"},
+	"department": {"department", "dept"},
+	"currency":   {"currency", "currency code"},
+	"subsidiary": {"subsidiary", "subsidiary name"},
+	"class":      {"class", "classification"},
+	"amount":     {"amount", "net amount"},
+	"debit":      {"debit", "amount (debit)", "debit amount"},
+	"credit":     {"credit", "amount (credit)", "credit amount"},
+	"memo":       {"memo", "description"},
+}
+
+// headerScanRows is how many leading rows are searched for the header row,
+// since saved-search exports put a title block above the column names
+const headerScanRows = 25
+
+// columnMapFromForm reads the optional "columnMap" form field, a JSON object
+// naming the source column for a transaction field, e.g. {"amount": "Amount (Net)"}.
+// Field names are matched case-insensitively and returned in their canonical form.
+func columnMapFromForm(form url.Values) (map[string]string, error) {
+	value := strings.TrimSpace(form.Get("columnMap"))
+	if value == "" {
+		return nil, nil
+	}
+
+	var raw map[string]string
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return nil, fmt.Errorf("columnMap must be a JSON object of field to column name")
+	}
+
+	columnMap := make(map[string]string, len(raw))
+	for key, column := range raw {
+		field := ""
+		for name := range columnAliases {
+			if strings.EqualFold(name, strings.TrimSpace(key)) {
+				field = name
+				break
+			}
+		}
+		if field == "" {
+			return nil, fmt.Errorf("unknown field %q in columnMap (expected one of %s)", key, strings.Join(columnFields(), ", "))
+		}
+		if strings.TrimSpace(column) == "" {
+			return nil, fmt.Errorf("columnMap field %q has an empty column name", key)
+		}
+		columnMap[field] = strings.TrimSpace(column)
+	}
+	return columnMap, nil
+}
+
+// columnFields returns the transaction fields that can be mapped, sorted by name
+func columnFields() []string {
+	fields := make([]string, 0, len(columnAliases))
+	for field := range columnAliases {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// columnLayout records which column of the file feeds each transaction field
+type columnLayout struct {
+	index   map[string]int
+	sources map[string]string
+}
+
+// resolveColumns matches a header row to the transaction fields. Columns named
+// in columnMap take precedence over the built-in aliases; a mapped column that
+// is missing from the header is an error rather than a silently empty field.
+func resolveColumns(header []string, columnMap map[string]string) (*columnLayout, error) {
+	colIndex := headerIndex(header)
+	layout := &columnLayout{
+		index:   make(map[string]int),
+		sources: make(map[string]string),
+	}
+
+	// Mapping Debit/Credit without Amount asks for debit-minus-credit amounts,
+	// so an Amount column the aliases happen to find must not win over it
+	_, mapsAmount := columnMap["amount"]
+	_, mapsDebit := columnMap["debit"]
+	_, mapsCredit := columnMap["credit"]
+	skipAmountAliases := !mapsAmount && (mapsDebit || mapsCredit)
+
+	for field, aliases := range columnAliases {
+		if column, ok := columnMap[field]; ok {
+			idx, found := colIndex[strings.ToLower(column)]
+			if !found {
+				return nil, fmt.Errorf("column %q mapped to %s was not found in the header", column, field)
+			}
+			layout.index[field] = idx
+			layout.sources[field] = strings.TrimSpace(header[idx])
+			continue
+		}
+		if field == "amount" && skipAmountAliases {
+			continue
+		}
+		for _, alias := range aliases {
+			if idx, found := colIndex[alias]; found {
+				layout.index[field] = idx
+				layout.sources[field] = strings.TrimSpace(header[idx])
+				break
+			}
+		}
+	}
+
+	return layout, nil
+}
+
+// resolveAliases matches a header row to the fields of a small table, such as
+// a budget, by the headers recognized for each field
+func resolveAliases(header []string, aliases map[string][]string) *columnLayout {
+	colIndex := headerIndex(header)
+	layout := &columnLayout{
+		index:   make(map[string]int),
+		sources: make(map[string]string),
+	}
+	for field, names := range aliases {
+		for _, name := range names {
+			if idx, found := colIndex[name]; found {
+				layout.index[field] = idx
+				layout.sources[field] = strings.TrimSpace(header[idx])
+				break
+			}
+		}
+	}
+	return layout
+}
+
+// has reports whether any of the fields has a column
+func (layout *columnLayout) has(fields ...string) bool {
+	for _, field := range fields {
+		if _, ok := layout.index[field]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// field returns the trimmed value of a field's column, or "" when the row is short or the column is absent
+func (layout *columnLayout) field(record []string, field string) string {
+	if idx, ok := layout.index[field]; ok && idx < len(record) {
+		return strings.TrimSpace(record[idx])
+	}
+	return ""
+}
+
+// isTransactionHeader reports whether the layout looks like a transaction export
+// header, i.e. it has an account column and an amount or debit/credit column
+func (layout *columnLayout) isTransactionHeader() bool {
+	return layout.has("account") && layout.has("amount", "debit", "credit")
+}
+
+// headerIndex maps the lower-cased, trimmed header names of a row to their column
+func headerIndex(row []string) map[string]int {
+	colIndex := make(map[string]int)
+	for i, col := range row {
+		colIndex[strings.ToLower(strings.TrimSpace(col))] = i
+	}
+	return colIndex
+}
+
+// findHeaderRow returns the index of the row among the first headerScanRows that
+// looks like a transaction header and names the most known columns, or -1
+func findHeaderRow(rows [][]string, columnMap map[string]string) int {
+	best, bestScore := -1, 0
+	for i := 0; i < headerScanRows && i < len(rows); i++ {
+		layout, err := resolveColumns(rows[i], columnMap)
+		if err != nil || !layout.isTransactionHeader() {
+			continue
+		}
+		if score := len(layout.index); score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
@@ -0,0 +1,333 @@
+package analyzer
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// Report types that can be compared, selected by the "type" field
+const (
+	CompareTypeTransaction = "transaction"
+	CompareTypeQuarterly   = "quarterly"
+)
+
+// Kinds of compared lines, which decide the favorable direction of a change
+const (
+	lineKindRevenue = "revenue"
+	lineKindExpense = "expense"
+	lineKindProfit  = "profit"
+	lineKindMargin  = "margin"
+)
+
+// Variance flags
+const (
+	varianceFavorable   = "favorable"
+	varianceUnfavorable = "unfavorable"
+)
+
+// Comparison lines up a current and a prior report. For quarterly statements
+// Lines holds each department's net income and Departments the statement lines.
+type Comparison struct {
+	Type         string                 `json:"type"`
+	CurrentLabel string                 `json:"currentLabel"`
+	PriorLabel   string                 `json:"priorLabel"`
+	Lines        []VarianceLine         `json:"lines"`
+	Departments  []DepartmentComparison `json:"departments,omitempty"`
+}
+
+// DepartmentComparison compares the statement lines of one department
+type DepartmentComparison struct {
+	Department string         `json:"department"`
+	Lines      []VarianceLine `json:"lines"`
+}
+
+// VarianceLine is a report line with both values. VariancePercent is relative
+// to the prior value and null when the prior value is zero; margins vary in
+// percentage points. Flag says whether the change is favorable for the kind of
+// line: more revenue or profit is favorable, more expense is not.
+type VarianceLine struct {
+	Section         string   `json:"section,omitempty"`
+	Label           string   `json:"label"`
+	Depth           int      `json:"depth,omitempty"`
+	Kind            string   `json:"kind,omitempty"`
+	Current         float64  `json:"current"`
+	Prior           float64  `json:"prior"`
+	Variance        float64  `json:"variance"`
+	VariancePercent *float64 `json:"variancePercent"`
+	Flag            string   `json:"flag,omitempty"`
+}
+
+// ComparedUpload is one side of a comparison
+type ComparedUpload struct {
+	PL        *PLReport
+	Quarterly *QuarterlyReport
+}
+
+// CompareUpload reads one side of a comparison as the requested report type
+func CompareUpload(file io.Reader, filename string, form url.Values, mapping *AccountMapping, departments *DepartmentConfig, rates *FXTable) (*ComparedUpload, error) {
+	switch strings.ToLower(strings.TrimSpace(form.Get("type"))) {
+	case "", CompareTypeTransaction:
+		report, err := AnalyzeUpload(file, filename, form, mapping, rates)
+		if err != nil {
+			return nil, err
+		}
+		return &ComparedUpload{PL: report}, nil
+	case CompareTypeQuarterly:
+	default:
+		return nil, requestError("Invalid type: ", fmt.Errorf("%q (expected transaction or quarterly)", form.Get("type")))
+	}
+
+	ext := strings.ToLower(filepath.Ext(filename))
+	if ext != ".xlsx" && ext != ".xls" {
+		return nil, requestError("", fmt.Errorf("quarterly income statements must be in Excel format (.xlsx or .xls)"))
+	}
+	layout, err := QuarterlyLayoutFromForm(form)
+	if err != nil {
+		return nil, requestError("Invalid layout: ", err)
+	}
+	opts := QuarterlyOptions{
+		Sheet:       strings.TrimSpace(form.Get("sheet")),
+		Layout:      layout,
+		Departments: departments,
+	}
+	report, err := ParseQuarterlyIncomeStatement(file, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &ComparedUpload{Quarterly: report}, nil
+}
+
+// newVarianceLine computes the variance of a line and flags it by its kind
+func newVarianceLine(section, label, kind string, current, prior float64) VarianceLine {
+	line := VarianceLine{
+		Section:  section,
+		Label:    label,
+		Kind:     kind,
+		Current:  current,
+		Prior:    prior,
+		Variance: current - prior,
+	}
+	if kind != lineKindMargin && prior != 0 {
+		percent := line.Variance / math.Abs(prior) * 100
+		line.VariancePercent = &percent
+	}
+	if kind == "" || math.Abs(line.Variance) <= balanceTolerance {
+		return line
+	}
+
+	favorable := line.Variance > 0
+	if kind == lineKindExpense {
+		favorable = !favorable
+	}
+	line.Flag = varianceUnfavorable
+	if favorable {
+		line.Flag = varianceFavorable
+	}
+	return line
+}
+
+// ComparePLReports lines up every line of two P&L reports: revenue, each COGS
+// and OpEx subcategory with its category's totals, and the profit lines
+func ComparePLReports(current, prior *PLReport) []VarianceLine {
+	lines := []VarianceLine{newVarianceLine("", "Revenue", lineKindRevenue, current.Revenue, prior.Revenue)}
+	lines = append(lines, compareCategory("COGS", current.COGS, prior.COGS)...)
+	lines = append(lines,
+		newVarianceLine("", "Gross Profit", lineKindProfit, current.GrossProfit, prior.GrossProfit),
+		newVarianceLine("", "Gross Margin", lineKindMargin, current.GrossMargin, prior.GrossMargin),
+	)
+	for _, name := range mergeOrder(opexCategoryOrder(current.OpEx), opexCategoryOrder(prior.OpEx)) {
+		lines = append(lines, compareCategory(name, current.OpEx[name], prior.OpEx[name])...)
+	}
+	return append(lines,
+		newVarianceLine("", "Total Operating Expenses", lineKindExpense, current.TotalOpEx, prior.TotalOpEx),
+		newVarianceLine("", "EBITDA", lineKindProfit, current.EBITDA, prior.EBITDA),
+	)
+}
+
+// compareCategory lines up the subcategories of a category, followed by its
+// total and headcount split. A category missing from one report counts as zero.
+func compareCategory(name string, current, prior *PLCategory) []VarianceLine {
+	if current == nil {
+		current = &PLCategory{Name: name}
+	}
+	if prior == nil {
+		prior = &PLCategory{Name: name}
+	}
+
+	var lines []VarianceLine
+	for _, subName := range mergeOrder(subcategoryNames(current), subcategoryNames(prior)) {
+		var c, p float64
+		if subcat := current.Subcategories[subName]; subcat != nil {
+			c = subcat.Total
+		}
+		if subcat := prior.Subcategories[subName]; subcat != nil {
+			p = subcat.Total
+		}
+		line := newVarianceLine(name, subName, lineKindExpense, c, p)
+		line.Depth = 1
+		lines = append(lines, line)
+	}
+
+	lines = append(lines, newVarianceLine(name, "Total "+name, lineKindExpense, current.Total, prior.Total))
+	for _, split := range []struct {
+		label          string
+		current, prior float64
+	}{
+		{"Headcount", current.Headcount, prior.Headcount},
+		{"Non-Headcount", current.NonHeadcount, prior.NonHeadcount},
+	} {
+		line := newVarianceLine(name, split.label, lineKindExpense, split.current, split.prior)
+		line.Depth = 1
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// subcategoryNames lists a category's subcategories, largest first
+func subcategoryNames(cat *PLCategory) []string {
+	var names []string
+	for _, subcat := range sortedSubcategories(cat) {
+		names = append(names, subcat.Name)
+	}
+	return names
+}
+
+// mergeOrder lists the current names in order, followed by names only the prior report has
+func mergeOrder(current, prior []string) []string {
+	names := append([]string{}, current...)
+	for _, name := range prior {
+		if !containsString(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// CompareQuarterlyReports compares each department's net income and its
+// statement lines. Lines are matched by label; a label used more than once in
+// a department is matched by its position among the lines sharing it.
+func CompareQuarterlyReports(current, prior *QuarterlyReport, mapping *AccountMapping) ([]VarianceLine, []DepartmentComparison) {
+	lines := []VarianceLine{}
+	depts := []DepartmentComparison{}
+	var currentTotal, priorTotal float64
+
+	names := mergeOrder(departmentOrder(current.DepartmentTree), departmentOrder(prior.DepartmentTree))
+	for _, name := range names {
+		lines = append(lines, newVarianceLine("", name, lineKindProfit, current.Summary[name], prior.Summary[name]))
+		currentTotal += current.Summary[name]
+		priorTotal += prior.Summary[name]
+
+		var currentEntries, priorEntries []statementEntry
+		if deptData := current.Departments[name]; deptData != nil {
+			currentEntries = flattenStatement(deptData.Tree, name, mapping)
+		}
+		if deptData := prior.Departments[name]; deptData != nil {
+			priorEntries = flattenStatement(deptData.Tree, name, mapping)
+		}
+		depts = append(depts, DepartmentComparison{
+			Department: name,
+			Lines:      compareStatementEntries(currentEntries, priorEntries),
+		})
+	}
+	lines = append(lines, newVarianceLine("", "Total", lineKindProfit, currentTotal, priorTotal))
+
+	return lines, depts
+}
+
+// statementEntry is a line of a department's statement, keyed for matching
+type statementEntry struct {
+	key    string
+	label  string
+	depth  int
+	kind   string
+	amount float64
+}
+
+// flattenStatement lists a department's lines in statement order with their
+// rolled-up totals and the kind of each line
+func flattenStatement(tree []*LineItemNode, department string, mapping *AccountMapping) []statementEntry {
+	var entries []statementEntry
+	occurrences := make(map[string]int)
+
+	var walk func(nodes []*LineItemNode, depth, section int)
+	walk = func(nodes []*LineItemNode, depth, section int) {
+		for _, node := range nodes {
+			nodeSection := sectionOf(node.Label, section)
+			label := strings.ToLower(node.Label)
+			occurrences[label]++
+			entries = append(entries, statementEntry{
+				key:    fmt.Sprintf("%s#%d", label, occurrences[label]),
+				label:  node.Label,
+				depth:  depth,
+				kind:   statementLineKind(node, nodeSection, department, mapping),
+				amount: node.Total,
+			})
+			walk(node.Children, depth+1, nodeSection)
+		}
+	}
+	walk(tree, 0, sectionNone)
+	return entries
+}
+
+// statementLineKind decides whether a statement line is revenue, expense or a
+// computed result. The statement section decides first; lines outside any
+// section are classified with the account mapping, and headings outside a
+// section are left without a kind.
+func statementLineKind(node *LineItemNode, section int, department string, mapping *AccountMapping) string {
+	if isSummaryLine(node.Label) {
+		return lineKindProfit
+	}
+	switch section {
+	case sectionIncome:
+		return lineKindRevenue
+	case sectionExpense:
+		return lineKindExpense
+	}
+	// Headings such as "Ordinary Income/Expense" span both sections
+	lower := strings.ToLower(node.Label)
+	if len(node.Children) > 0 || (strings.Contains(lower, "income") && strings.Contains(lower, "expense")) {
+		return ""
+	}
+
+	trans := Transaction{Account: node.Label, Department: department}
+	trans.AccountNumber, trans.AccountName = splitAccount(node.Label)
+	switch mapping.classify(trans).Bucket {
+	case bucketRevenue:
+		return lineKindRevenue
+	case bucketCOGS, bucketOpEx:
+		return lineKindExpense
+	}
+	return ""
+}
+
+// compareStatementEntries matches the lines of two statements, keeping the
+// current statement's order and adding lines only the prior one has
+func compareStatementEntries(current, prior []statementEntry) []VarianceLine {
+	priorByKey := make(map[string]statementEntry, len(prior))
+	for _, entry := range prior {
+		priorByKey[entry.key] = entry
+	}
+
+	lines := []VarianceLine{}
+	matched := make(map[string]bool)
+	for _, entry := range current {
+		priorEntry, ok := priorByKey[entry.key]
+		matched[entry.key] = ok
+		line := newVarianceLine("", entry.label, entry.kind, entry.amount, priorEntry.amount)
+		line.Depth = entry.depth
+		lines = append(lines, line)
+	}
+	for _, entry := range prior {
+		if matched[entry.key] {
+			continue
+		}
+		line := newVarianceLine("", entry.label, entry.kind, 0, entry.amount)
+		line.Depth = entry.depth
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,173 @@
+package analyzer
+
+import "testing"
+
+// varianceCheck is the expected outcome of one compared line
+type varianceCheck struct {
+	section, label string
+	depth          int
+	current, prior float64
+	flag           string
+}
+
+// checkVarianceLines compares lines in order against the expected outcomes
+func checkVarianceLines(t *testing.T, got []VarianceLine, want []varianceCheck) {
+	t.Helper()
+	if len(got) != len(want) {
+		var labels []string
+		for _, line := range got {
+			labels = append(labels, line.Label)
+		}
+		t.Fatalf("got %d lines %q, want %d", len(got), labels, len(want))
+	}
+	for i, w := range want {
+		line := got[i]
+		if line.Section != w.section || line.Label != w.label || line.Depth != w.depth {
+			t.Errorf("line %d = %s/%s (depth %d), want %s/%s (depth %d)", i, line.Section, line.Label, line.Depth, w.section, w.label, w.depth)
+			continue
+		}
+		if line.Current != w.current || line.Prior != w.prior {
+			t.Errorf("%s/%s = %v vs %v, want %v vs %v", w.section, w.label, line.Current, line.Prior, w.current, w.prior)
+		}
+		if line.Flag != w.flag {
+			t.Errorf("%s/%s flag = %q, want %q", w.section, w.label, line.Flag, w.flag)
+		}
+	}
+}
+
+func TestNewVarianceLine(t *testing.T) {
+	pct := func(v float64) *float64 { return &v }
+	tests := []struct {
+		name           string
+		kind           string
+		current, prior float64
+		flag           string
+		// percent is the expected VariancePercent; nil when none is reported
+		percent *float64
+	}{
+		{name: "revenue up", kind: lineKindRevenue, current: 120, prior: 100, flag: varianceFavorable, percent: pct(20)},
+		{name: "revenue down", kind: lineKindRevenue, current: 80, prior: 100, flag: varianceUnfavorable, percent: pct(-20)},
+		{name: "expense up", kind: lineKindExpense, current: 150, prior: 100, flag: varianceUnfavorable, percent: pct(50)},
+		{name: "expense down", kind: lineKindExpense, current: 50, prior: 100, flag: varianceFavorable, percent: pct(-50)},
+		{name: "loss narrowed", kind: lineKindProfit, current: -50, prior: -100, flag: varianceFavorable, percent: pct(50)},
+		{name: "margin in points", kind: lineKindMargin, current: 40, prior: 35, flag: varianceFavorable},
+		{name: "no prior value", kind: lineKindExpense, current: 10, prior: 0, flag: varianceUnfavorable},
+		{name: "unchanged within rounding", kind: lineKindRevenue, current: 100.001, prior: 100, percent: pct(0.001)},
+		{name: "no kind", current: 10, prior: 5, percent: pct(100)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line := newVarianceLine("", tt.name, tt.kind, tt.current, tt.prior)
+			if line.Flag != tt.flag {
+				t.Errorf("flag = %q, want %q", line.Flag, tt.flag)
+			}
+			switch {
+			case tt.percent == nil && line.VariancePercent != nil:
+				t.Errorf("percent = %v, want none", *line.VariancePercent)
+			case tt.percent != nil && line.VariancePercent == nil:
+				t.Errorf("no percent, want %v", *tt.percent)
+			case tt.percent != nil && !closeTo(*line.VariancePercent, *tt.percent):
+				t.Errorf("percent = %v, want %v", *line.VariancePercent, *tt.percent)
+			}
+		})
+	}
+}
+
+// closeTo compares floats computed by division
+func closeTo(a, b float64) bool {
+	return a-b < 1e-9 && b-a < 1e-9
+}
+
+func TestComparePLReports(t *testing.T) {
+	subcats := func(totals map[string]float64) map[string]*PLSubcategory {
+		m := make(map[string]*PLSubcategory)
+		for name, total := range totals {
+			m[name] = &PLSubcategory{Name: name, Total: total}
+		}
+		return m
+	}
+	current := &PLReport{
+		Revenue:     1200,
+		COGS:        &PLCategory{Name: "COGS", Total: 300, NonHeadcount: 300, Subcategories: subcats(map[string]float64{"Hosting": 300})},
+		GrossProfit: 900,
+		GrossMargin: 75,
+		OpEx: map[string]*PLCategory{
+			"R&D": {Name: "R&D", Total: 500, Headcount: 400, NonHeadcount: 100, Subcategories: subcats(map[string]float64{"Salaries": 400, "Software": 100})},
+		},
+		TotalOpEx: 500,
+		EBITDA:    400,
+	}
+	prior := &PLReport{
+		Revenue:     1000,
+		COGS:        &PLCategory{Name: "COGS", Total: 250, NonHeadcount: 250, Subcategories: subcats(map[string]float64{"Hosting": 200, "Support": 50})},
+		GrossProfit: 750,
+		GrossMargin: 75,
+		OpEx: map[string]*PLCategory{
+			"R&D": {Name: "R&D", Total: 450, Headcount: 400, NonHeadcount: 50, Subcategories: subcats(map[string]float64{"Salaries": 400, "Software": 50})},
+		},
+		TotalOpEx: 450,
+		EBITDA:    300,
+	}
+
+	checkVarianceLines(t, ComparePLReports(current, prior), []varianceCheck{
+		{label: "Revenue", current: 1200, prior: 1000, flag: varianceFavorable},
+		{section: "COGS", label: "Hosting", depth: 1, current: 300, prior: 200, flag: varianceUnfavorable},
+		{section: "COGS", label: "Support", depth: 1, current: 0, prior: 50, flag: varianceFavorable},
+		{section: "COGS", label: "Total COGS", current: 300, prior: 250, flag: varianceUnfavorable},
+		{section: "COGS", label: "Headcount", depth: 1},
+		{section: "COGS", label: "Non-Headcount", depth: 1, current: 300, prior: 250, flag: varianceUnfavorable},
+		{label: "Gross Profit", current: 900, prior: 750, flag: varianceFavorable},
+		{label: "Gross Margin", current: 75, prior: 75},
+		{section: "R&D", label: "Salaries", depth: 1, current: 400, prior: 400},
+		{section: "R&D", label: "Software", depth: 1, current: 100, prior: 50, flag: varianceUnfavorable},
+		{section: "R&D", label: "Total R&D", current: 500, prior: 450, flag: varianceUnfavorable},
+		{section: "R&D", label: "Headcount", depth: 1, current: 400, prior: 400},
+		{section: "R&D", label: "Non-Headcount", depth: 1, current: 100, prior: 50, flag: varianceUnfavorable},
+		{label: "Total Operating Expenses", current: 500, prior: 450, flag: varianceUnfavorable},
+		{label: "EBITDA", current: 400, prior: 300, flag: varianceFavorable},
+	})
+}
+
+func TestCompareQuarterlyReports(t *testing.T) {
+	line := func(label string, total float64, children ...*LineItemNode) *LineItemNode {
+		return &LineItemNode{Label: label, Total: total, Children: children}
+	}
+	statement := func(netIncome float64, tree ...*LineItemNode) *QuarterlyReport {
+		return &QuarterlyReport{
+			Departments:    map[string]*DepartmentData{"G&A": {Department: "G&A", Tree: tree}},
+			Summary:        map[string]float64{"G&A": netIncome},
+			DepartmentTree: []*DepartmentNode{{Name: "G&A", HasColumn: true}},
+		}
+	}
+	// "Other" appears twice in the expense section and is matched by position
+	current := statement(20,
+		line("Income", 100, line("4000 - Revenue", 100)),
+		line("Expense", 80, line("Other", 10), line("6100 - Salaries", 50), line("Other", 20)),
+		line("Net Income", 20),
+	)
+	prior := statement(53,
+		line("Income", 80, line("4000 - Revenue", 80)),
+		line("Expense", 27, line("Other", 15), line("Other", 5), line("6200 - Rent", 7)),
+		line("Net Income", 53),
+	)
+
+	lines, depts := CompareQuarterlyReports(current, prior, nil)
+	checkVarianceLines(t, lines, []varianceCheck{
+		{label: "G&A", current: 20, prior: 53, flag: varianceUnfavorable},
+		{label: "Total", current: 20, prior: 53, flag: varianceUnfavorable},
+	})
+	if len(depts) != 1 || depts[0].Department != "G&A" {
+		t.Fatalf("got departments %+v, want G&A", depts)
+	}
+	checkVarianceLines(t, depts[0].Lines, []varianceCheck{
+		{label: "Income", current: 100, prior: 80, flag: varianceFavorable},
+		{label: "4000 - Revenue", depth: 1, current: 100, prior: 80, flag: varianceFavorable},
+		{label: "Expense", current: 80, prior: 27, flag: varianceUnfavorable},
+		{label: "Other", depth: 1, current: 10, prior: 15, flag: varianceFavorable},
+		{label: "6100 - Salaries", depth: 1, current: 50, prior: 0, flag: varianceUnfavorable},
+		{label: "Other", depth: 1, current: 20, prior: 5, flag: varianceUnfavorable},
+		{label: "Net Income", current: 20, prior: 53, flag: varianceUnfavorable},
+		{label: "6200 - Rent", depth: 1, current: 0, prior: 7, flag: varianceFavorable},
+	})
+}
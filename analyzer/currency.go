@@ -0,0 +1,315 @@
+package analyzer
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// defaultReportingCurrency is the currency reports are in when "currency" is not
+// set, and the currency of transactions without one when "baseCurrency" is not set
+const defaultReportingCurrency = "USD"
+
+// currencySymbols maps the symbols recognized in amounts to their ISO codes.
+// "US$" comes before "$" so the longer symbol is matched first.
+var currencySymbols = []struct {
+	symbol string
+	code   string
+}{
+	{"US$", "USD"},
+	{"$", "USD"},
+	{"€", "EUR"},
+	{"£", "GBP"},
+	{"¥", "JPY"},
+}
+
+// currencyNames maps the names NetSuite gives its currency records to their ISO codes
+var currencyNames = map[string]string{
+	"us dollar":         "USD",
+	"euro":              "EUR",
+	"british pound":     "GBP",
+	"pound sterling":    "GBP",
+	"canadian dollar":   "CAD",
+	"australian dollar": "AUD",
+	"japanese yen":      "JPY",
+}
+
+// currencyCodePattern matches a three-letter ISO currency code
+var currencyCodePattern = regexp.MustCompile(`^[A-Za-z]{3}$`)
+
+// Amounts written with an ISO code before or after the number, as in "EUR 1,200.00"
+var (
+	leadingCodePattern  = regexp.MustCompile(`^([A-Za-z]{3})\s*([-(0-9.,].*)$`)
+	trailingCodePattern = regexp.MustCompile(`^(.*[0-9.)])\s*([A-Za-z]{3})$`)
+)
+
+// fxColumnAliases lists the headers recognized for each column of an FX rate table
+var fxColumnAliases = map[string][]string{
+	"month": {"month", "period", "date"},
+	"from":  {"from", "currency", "from currency"},
+	"to":    {"to", "to currency", "reporting currency"},
+	"rate":  {"rate", "average rate", "avg rate", "exchange rate"},
+}
+
+// CurrencyConversion records the transactions converted from one currency in
+// one month and the rate used, with their total before and after conversion
+// as in the file, so the converted report can be audited
+type CurrencyConversion struct {
+	Currency       string  `json:"currency"`
+	Month          string  `json:"month"`
+	Rate           float64 `json:"rate"`
+	Count          int     `json:"count"`
+	OriginalAmount float64 `json:"originalAmount"`
+	Amount         float64 `json:"amount"`
+}
+
+// normalizeCurrency returns the ISO code of a currency given as a code or a
+// NetSuite currency name, reporting whether it was recognized
+func normalizeCurrency(s string) (string, bool) {
+	s = strings.Join(strings.Fields(s), " ")
+	if currencyCodePattern.MatchString(s) {
+		return strings.ToUpper(s), true
+	}
+	code, ok := currencyNames[strings.ToLower(s)]
+	return code, ok
+}
+
+// currencyFromForm reads a currency form field such as "currency" or "baseCurrency"
+func currencyFromForm(form url.Values, field string) (string, error) {
+	value := strings.TrimSpace(form.Get(field))
+	if value == "" {
+		return defaultReportingCurrency, nil
+	}
+	code, ok := normalizeCurrency(value)
+	if !ok {
+		return "", fmt.Errorf("unknown currency %q (expected an ISO code such as USD)", value)
+	}
+	return code, nil
+}
+
+// parseMoney parses an amount that may carry a currency symbol ("€1,200.00")
+// or ISO code ("EUR 1,200.00", "1,200.00 EUR"), returning the currency found
+// or "" when there is none
+func parseMoney(s string) (float64, string, error) {
+	s = strings.TrimSpace(s)
+	currency := ""
+	for _, symbol := range currencySymbols {
+		if strings.Contains(s, symbol.symbol) {
+			s = strings.Replace(s, symbol.symbol, "", 1)
+			currency = symbol.code
+			break
+		}
+	}
+	if currency == "" {
+		if m := leadingCodePattern.FindStringSubmatch(s); m != nil {
+			s, currency = m[2], strings.ToUpper(m[1])
+		} else if m := trailingCodePattern.FindStringSubmatch(s); m != nil {
+			s, currency = m[1], strings.ToUpper(m[2])
+		}
+	}
+
+	amount, err := parseAmount(s)
+	return amount, currency, err
+}
+
+// FXTable holds monthly average exchange rates. A rate converts one unit of
+// the from currency into the to currency; rates without a to currency convert
+// into the reporting currency.
+type FXTable struct {
+	rates map[fxKey]float64
+}
+
+// fxKey identifies a rate by currency pair and month ("2024-01")
+type fxKey struct {
+	from, to, month string
+}
+
+// ParseFXRates reads an FX rate table (CSV or Excel) with Month, From and Rate
+// columns and an optional To column. Months may be written as "2024-01",
+// "Jan 2024" or as any date within the month.
+func ParseFXRates(data []byte, filename string) (*FXTable, error) {
+	rows, err := readTable(data, filename, func(rows [][]string) bool {
+		return findFXHeaderRow(rows) >= 0
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	headerRow := findFXHeaderRow(rows)
+	if headerRow < 0 {
+		return nil, fmt.Errorf("no FX rate header found (expected Month, Currency and Rate columns)")
+	}
+	layout := resolveAliases(rows[headerRow], fxColumnAliases)
+
+	table := &FXTable{rates: make(map[fxKey]float64)}
+	for i := headerRow + 1; i < len(rows); i++ {
+		record := rows[i]
+		if isBlankRecord(record) {
+			continue
+		}
+		key, rate, err := parseFXRow(record, layout)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", i+1, err)
+		}
+		table.rates[key] = rate
+	}
+	return table, nil
+}
+
+// findFXHeaderRow returns the index of the first row among the first
+// headerScanRows that names the month, currency and rate columns, or -1
+func findFXHeaderRow(rows [][]string) int {
+	for i := 0; i < headerScanRows && i < len(rows); i++ {
+		layout := resolveAliases(rows[i], fxColumnAliases)
+		if layout.has("month") && layout.has("from") && layout.has("rate") {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseFXRow reads the currency pair, month and rate of one row of an FX rate table
+func parseFXRow(record []string, layout *columnLayout) (fxKey, float64, error) {
+	var key fxKey
+
+	raw := layout.field(record, "month")
+	month, ok := parseMonthHeader(raw)
+	if !ok {
+		date, err := parseDate(raw, "")
+		if err != nil {
+			return key, 0, fmt.Errorf("month %q: %v", raw, err)
+		}
+		month = date
+	}
+	key.month = month.Format("2006-01")
+
+	for _, field := range []struct {
+		name  string
+		value *string
+	}{
+		{"from", &key.from},
+		{"to", &key.to},
+	} {
+		raw := layout.field(record, field.name)
+		if raw == "" && field.name == "to" {
+			continue
+		}
+		code, ok := normalizeCurrency(raw)
+		if !ok {
+			return key, 0, fmt.Errorf("column %q: unknown currency %q", layout.sources[field.name], raw)
+		}
+		*field.value = code
+	}
+
+	rate, err := parseAmount(layout.field(record, "rate"))
+	if err != nil || rate <= 0 {
+		return key, 0, fmt.Errorf("column %q: rate must be a positive number", layout.sources["rate"])
+	}
+	return key, rate, nil
+}
+
+// rate returns the rate converting from into the reporting currency to in a
+// month, using the inverse of the opposite rate when only that one is given
+func (t *FXTable) rate(from, to, month string) (float64, bool) {
+	if from == to {
+		return 1, true
+	}
+	if t == nil {
+		return 0, false
+	}
+	if rate, ok := t.rates[fxKey{from, to, month}]; ok {
+		return rate, true
+	}
+	if rate, ok := t.rates[fxKey{from, "", month}]; ok {
+		return rate, true
+	}
+	if rate, ok := t.rates[fxKey{to, from, month}]; ok {
+		return 1 / rate, true
+	}
+	return 0, false
+}
+
+// currencyConverter converts transactions into the reporting currency and
+// keeps the audit trail of the conversions
+type currencyConverter struct {
+	currency string
+	// base is the currency of transactions that do not name one
+	base        string
+	rates       *FXTable
+	conversions map[fxKey]*CurrencyConversion
+}
+
+// newCurrencyConverter converts into currency with the given rates, which may
+// be nil, reading transactions without a currency as base
+func newCurrencyConverter(currency, base string, rates *FXTable) *currencyConverter {
+	return &currencyConverter{
+		currency:    currency,
+		base:        base,
+		rates:       rates,
+		conversions: make(map[fxKey]*CurrencyConversion),
+	}
+}
+
+// convert restates a foreign-currency transaction in the reporting currency at
+// the rate of its month, keeping the original amount. Transactions without a
+// currency are in the base currency. A transaction that cannot be converted is
+// left as it is and reported in the returned warning; its amount must not be
+// added to the reporting-currency totals.
+func (c *currencyConverter) convert(trans *Transaction) *ParseWarning {
+	from := trans.Currency
+	if from == "" {
+		from = c.base
+	}
+	if from == c.currency {
+		return nil
+	}
+
+	warning := &ParseWarning{
+		Sheet:  trans.Sheet,
+		Row:    trans.Row,
+		Column: "currency",
+		Value:  from,
+	}
+	if trans.PostingDate.IsZero() {
+		warning.Message = fmt.Sprintf("no date to look up the %s to %s rate", from, c.currency)
+		return warning
+	}
+	month := trans.PostingDate.Format("2006-01")
+	rate, ok := c.rates.rate(from, c.currency, month)
+	if !ok {
+		warning.Message = fmt.Sprintf("no %s to %s rate for %s", from, c.currency, month)
+		return warning
+	}
+
+	trans.Currency = from
+	trans.OriginalAmount = trans.Amount
+	trans.Amount *= rate
+
+	key := fxKey{trans.Currency, c.currency, month}
+	conversion, ok := c.conversions[key]
+	if !ok {
+		conversion = &CurrencyConversion{Currency: trans.Currency, Month: month, Rate: rate}
+		c.conversions[key] = conversion
+	}
+	conversion.Count++
+	conversion.OriginalAmount += trans.OriginalAmount
+	conversion.Amount += trans.Amount
+	return nil
+}
+
+// finish lists the conversions by currency and month
+func (c *currencyConverter) finish() []CurrencyConversion {
+	conversions := make([]CurrencyConversion, 0, len(c.conversions))
+	for _, conversion := range c.conversions {
+		conversions = append(conversions, *conversion)
+	}
+	sort.Slice(conversions, func(i, j int) bool {
+		if conversions[i].Currency != conversions[j].Currency {
+			return conversions[i].Currency < conversions[j].Currency
+		}
+		return conversions[i].Month < conversions[j].Month
+	})
+	return conversions
+}
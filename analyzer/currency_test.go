@@ -0,0 +1,149 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// testFXRates are January 2024 rates into US dollars
+const testFXRates = `Month,From,To,Rate
+2024-01,EUR,USD,1.10
+2024-01,GBP,USD,1.25
+`
+
+func TestCurrencyConversion(t *testing.T) {
+	rates, err := ParseFXRates([]byte(testFXRates), "rates.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		csv         string
+		form        url.Values
+		rates       *FXTable
+		revenue     float64
+		conversions []CurrencyConversion
+		// unconverted lists the rows rejected with a 422 for want of a rate
+		unconverted []int
+	}{
+		{
+			name: "foreign rows are converted at their month's rate",
+			csv: `Date,Account,Currency,Amount
+2024-01-10,4000 - Revenue,EUR,1000
+2024-01-20,4000 - Revenue,British Pound,100
+2024-01-25,4000 - Revenue,,50
+`,
+			rates:   rates,
+			revenue: 1275,
+			conversions: []CurrencyConversion{
+				{Currency: "EUR", Month: "2024-01", Rate: 1.10, Count: 1, OriginalAmount: 1000, Amount: 1100},
+				{Currency: "GBP", Month: "2024-01", Rate: 1.25, Count: 1, OriginalAmount: 100, Amount: 125},
+			},
+		},
+		{
+			name: "reporting currency uses the inverse rate",
+			csv: `Date,Account,Amount
+2024-01-10,4000 - Revenue,$1100
+`,
+			form:    url.Values{"currency": {"EUR"}},
+			rates:   rates,
+			revenue: 1000,
+			conversions: []CurrencyConversion{
+				{Currency: "USD", Month: "2024-01", Rate: 1 / 1.10, Count: 1, OriginalAmount: 1100, Amount: 1000},
+			},
+		},
+		{
+			name: "rows without a currency are in the base currency",
+			csv: `Date,Account,Amount
+2024-01-10,4000 - Revenue,1000
+`,
+			form:    url.Values{"baseCurrency": {"EUR"}},
+			rates:   rates,
+			revenue: 1100,
+			conversions: []CurrencyConversion{
+				{Currency: "EUR", Month: "2024-01", Rate: 1.10, Count: 1, OriginalAmount: 1000, Amount: 1100},
+			},
+		},
+		{
+			name: "rows without a rate reject the upload",
+			csv: `Date,Account,Currency,Amount
+2024-01-10,4000 - Revenue,EUR,1000
+2024-02-10,4000 - Revenue,EUR,1000
+2024-01-10,4000 - Revenue,CAD,1000
+`,
+			rates:       rates,
+			unconverted: []int{3, 4},
+		},
+		{
+			name: "foreign rows without a rate table reject the upload",
+			csv: `Date,Account,Amount
+2024-01-10,4000 - Revenue,€1000
+`,
+			unconverted: []int{2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := tt.form
+			if form == nil {
+				form = url.Values{}
+			}
+			report, err := AnalyzeUpload(strings.NewReader(tt.csv), "export.csv", form, nil, tt.rates)
+
+			if tt.unconverted != nil {
+				if err == nil {
+					t.Fatalf("got revenue %v, want the upload rejected", report.Revenue)
+				}
+				w := httptest.NewRecorder()
+				UploadError(w, "", err)
+				if w.Code != http.StatusUnprocessableEntity {
+					t.Fatalf("status = %d, want %d (%s)", w.Code, http.StatusUnprocessableEntity, w.Body)
+				}
+				var body struct {
+					Error    string         `json:"error"`
+					Warnings []ParseWarning `json:"warnings"`
+				}
+				if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+					t.Fatal(err)
+				}
+				var rows []int
+				for _, warning := range body.Warnings {
+					rows = append(rows, warning.Row)
+				}
+				if len(rows) != len(tt.unconverted) || body.Error == "" {
+					t.Fatalf("rejected rows %v (%q), want %v", rows, body.Error, tt.unconverted)
+				}
+				for i := range rows {
+					if rows[i] != tt.unconverted[i] {
+						t.Errorf("rejected rows %v, want %v", rows, tt.unconverted)
+						break
+					}
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !closeTo(report.Revenue, tt.revenue) {
+				t.Errorf("revenue = %v, want %v", report.Revenue, tt.revenue)
+			}
+			if len(report.Conversions) != len(tt.conversions) {
+				t.Fatalf("conversions = %+v, want %+v", report.Conversions, tt.conversions)
+			}
+			for i, want := range tt.conversions {
+				got := report.Conversions[i]
+				if got.Currency != want.Currency || got.Month != want.Month || got.Count != want.Count ||
+					!closeTo(got.Rate, want.Rate) || !closeTo(got.OriginalAmount, want.OriginalAmount) || !closeTo(got.Amount, want.Amount) {
+					t.Errorf("conversion %d = %+v, want %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
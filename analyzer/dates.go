@@ -0,0 +1,152 @@
+package analyzer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultDateLayouts are tried in order when the caller does not specify a date format
+var defaultDateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"1/2/2006",
+	"1/2/2006 15:04",
+	"1/2/2006 3:04 pm",
+	"1/2/06",
+	"2-Jan-2006",
+	"2-Jan-06",
+	"2 Jan 2006",
+	"Jan 2, 2006",
+	"January 2, 2006",
+	"01-02-06",
+}
+
+// dateFormatTokens maps the placeholders accepted in the "dateFormat" field to Go layout elements.
+// Longer tokens come first so "MMM" is not read as "MM" followed by "M".
+var dateFormatTokens = []struct {
+	token  string
+	layout string
+}{
+	{"YYYY", "2006"},
+	{"YY", "06"},
+	{"MMMM", "January"},
+	{"MMM", "Jan"},
+	{"MM", "01"},
+	{"M", "1"},
+	{"DD", "02"},
+	{"D", "2"},
+}
+
+// Excel stores dates as days since 1899-12-30; these bound the serials we accept
+const (
+	minExcelSerial = 1
+	maxExcelSerial = 2958465 // 9999-12-31
+)
+
+// dateLayoutFromFormat converts a caller-supplied format such as "DD/MM/YYYY"
+// into a Go time layout. An empty format selects auto-detection.
+func dateLayoutFromFormat(format string) (string, error) {
+	format = strings.TrimSpace(format)
+	if format == "" {
+		return "", nil
+	}
+
+	var layout strings.Builder
+	hasToken := false
+	for i := 0; i < len(format); {
+		matched := false
+		for _, t := range dateFormatTokens {
+			if strings.HasPrefix(strings.ToUpper(format[i:]), t.token) {
+				layout.WriteString(t.layout)
+				i += len(t.token)
+				matched = true
+				hasToken = true
+				break
+			}
+		}
+		if !matched {
+			layout.WriteByte(format[i])
+			i++
+		}
+	}
+
+	if !hasToken {
+		return "", fmt.Errorf("date format %q has no YYYY, MM or DD placeholders", format)
+	}
+	return layout.String(), nil
+}
+
+// parseDate converts a raw date cell into a time.Time using the given layout,
+// or the default layouts when layout is empty. Numbers that match no layout,
+// such as 45306, are read as Excel serials.
+func parseDate(s, layout string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, fmt.Errorf("missing date")
+	}
+
+	layouts := defaultDateLayouts
+	if layout != "" {
+		layouts = []string{layout}
+	}
+	for _, l := range layouts {
+		if t, err := time.Parse(l, s); err == nil {
+			return t, nil
+		}
+	}
+
+	if serial, err := strconv.ParseFloat(s, 64); err == nil {
+		if serial < minExcelSerial || serial > maxExcelSerial {
+			return time.Time{}, fmt.Errorf("number is outside the Excel date range")
+		}
+		base := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+		return base.AddDate(0, 0, int(serial)), nil
+	}
+
+	if layout != "" {
+		return time.Time{}, fmt.Errorf("date does not match the expected format")
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date format")
+}
+
+// normalizeDate parses the transaction's date, returning a warning when it cannot be read
+func normalizeDate(trans *Transaction, layout string) *ParseWarning {
+	date, err := parseDate(trans.Date, layout)
+	if err != nil {
+		return &ParseWarning{
+			Sheet:   trans.Sheet,
+			Row:     trans.Row,
+			Column:  "date",
+			Value:   trans.Date,
+			Message: err.Error(),
+		}
+	}
+	trans.PostingDate = date
+	return nil
+}
+
+// monthHeaderLayouts are the captions used for month columns in financial statements, e.g. "Jan 2025"
+var monthHeaderLayouts = []string{
+	"Jan 2006",
+	"January 2006",
+	"Jan-06",
+	"Jan-2006",
+	"Jan '06",
+	"2006-01",
+	"01/2006",
+	"1/2006",
+}
+
+// parseMonthHeader reads a month column caption, returning the first day of that month
+func parseMonthHeader(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range monthHeaderLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
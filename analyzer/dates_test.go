@@ -0,0 +1,48 @@
+package analyzer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		format string
+		want   time.Time
+		// wantErr is the expected error message; empty means the date parses
+		wantErr string
+	}{
+		{name: "YYYYMMDD", value: "20240115", format: "YYYYMMDD", want: time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{name: "DD/MM/YYYY", value: "15/01/2024", format: "DD/MM/YYYY", want: time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{name: "DD/MM/YYYY rejects US order", value: "01/15/2024", format: "DD/MM/YYYY", wantErr: "date does not match the expected format"},
+		{name: "Excel serial", value: "45306", want: time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{name: "Excel serial with a format", value: "45306", format: "DD/MM/YYYY", want: time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{name: "serial out of range", value: "20240115", wantErr: "number is outside the Excel date range"},
+		{name: "auto-detected ISO", value: "2024-01-15", want: time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{name: "missing", value: " ", wantErr: "missing date"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layout, err := dateLayoutFromFormat(tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseDate(tt.value, layout)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("parseDate(%q) error = %v, want %q", tt.value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseDate(%q): %v", tt.value, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("parseDate(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
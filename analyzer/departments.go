@@ -0,0 +1,225 @@
+package analyzer
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// departmentsFileEnv names the environment variable pointing at the server-side department config
+const departmentsFileEnv = "DEPARTMENTS_FILE"
+
+// departmentSeparator joins the levels of a NetSuite department, as in "R&D : Platform"
+const departmentSeparator = " : "
+
+// DepartmentConfig extends the departments recognized in quarterly statements
+type DepartmentConfig struct {
+	Departments []DepartmentRule `json:"departments" yaml:"departments"`
+	// DiscoverAll treats every header on the department row as a department
+	DiscoverAll bool `json:"discoverAll,omitempty" yaml:"discoverAll,omitempty"`
+}
+
+// DepartmentRule names a department recognized in addition to the built-in ones.
+// Headers matching any of its aliases are reported, and merged, under Name.
+type DepartmentRule struct {
+	Name    string   `json:"name" yaml:"name"`
+	Aliases []string `json:"aliases,omitempty" yaml:"aliases,omitempty"`
+}
+
+// builtinDepartmentAliases maps common spellings of the built-in departments to their canonical name
+var builtinDepartmentAliases = map[string]string{
+	"g&a":                        "G&A",
+	"g & a":                      "G&A",
+	"general & administrative":   "G&A",
+	"general and administrative": "G&A",
+	"r&d":                        "R&D",
+	"r & d":                      "R&D",
+	"research & development":     "R&D",
+	"research and development":   "R&D",
+	"marketing":                  "Marketing",
+	"sales":                      "Sales",
+	"revenue":                    "Revenue",
+	"revenues":                   "Revenue",
+	"cogs":                       "COGS",
+	"cost of revenue":            "COGS",
+	"cost of goods sold":         "COGS",
+}
+
+// DepartmentNode is a department with its NetSuite sub-departments. Total is the
+// department's own column; Rollup adds the rollups of its sub-departments.
+type DepartmentNode struct {
+	Name      string            `json:"name"`
+	HasColumn bool              `json:"hasColumn"`
+	Total     float64           `json:"total"`
+	Rollup    float64           `json:"rollup"`
+	Children  []*DepartmentNode `json:"children,omitempty"`
+}
+
+// ParseDepartmentConfig decodes a YAML or JSON department config
+func ParseDepartmentConfig(data []byte) (*DepartmentConfig, error) {
+	config := &DepartmentConfig{}
+	// JSON is a subset of YAML, so a single decoder handles both formats
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("invalid department config: %w", err)
+	}
+
+	for i, rule := range config.Departments {
+		if strings.TrimSpace(rule.Name) == "" {
+			return nil, fmt.Errorf("department %d: name is required", i+1)
+		}
+	}
+	return config, nil
+}
+
+// LoadDefaultDepartmentConfig reads the server-side department config, if one is configured
+func LoadDefaultDepartmentConfig() (*DepartmentConfig, error) {
+	path := os.Getenv(departmentsFileEnv)
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read default department config: %w", err)
+	}
+	return ParseDepartmentConfig(data)
+}
+
+// isDepartment reports whether a header names a known department: a built-in
+// one, a configured one, or a sub-department ("R&D : Platform") of either
+func (c *DepartmentConfig) isDepartment(header string) bool {
+	header = strings.TrimSpace(header)
+	if header == "" || isTotalHeader(header) {
+		return false
+	}
+
+	top := departmentPath(header)[0]
+	if isMainDepartment(header) || isMainDepartment(top) {
+		return true
+	}
+	if c != nil {
+		for _, rule := range c.Departments {
+			if rule.matches(header) || rule.matches(top) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matches reports whether a name is the rule's department or one of its aliases
+func (rule DepartmentRule) matches(name string) bool {
+	name = strings.TrimSpace(name)
+	if strings.EqualFold(strings.TrimSpace(rule.Name), name) {
+		return true
+	}
+	return matchesAny(rule.Aliases, name)
+}
+
+// canonicalName normalizes a department header so spellings of the same
+// department ("General & Administrative", "G&A", "g&a") share one name. Each
+// level of a "Parent : Child" name is normalized on its own. Configured names
+// and aliases win over the built-in ones; other names only have their
+// whitespace tidied.
+func (c *DepartmentConfig) canonicalName(header string) string {
+	path := departmentPath(header)
+	for i, level := range path {
+		level = strings.Join(strings.Fields(level), " ")
+		path[i] = level
+
+		if c != nil {
+			matched := false
+			for _, rule := range c.Departments {
+				if rule.matches(level) {
+					path[i] = strings.TrimSpace(rule.Name)
+					matched = true
+					break
+				}
+			}
+			if matched {
+				continue
+			}
+		}
+		if name, ok := builtinDepartmentAliases[strings.ToLower(level)]; ok {
+			path[i] = name
+		}
+	}
+	return strings.Join(path, departmentSeparator)
+}
+
+// acceptsHeader reports whether a header on the department row is a department.
+// With DiscoverAll any header counts except column totals.
+func (c *DepartmentConfig) acceptsHeader(header string) bool {
+	if c != nil && c.DiscoverAll {
+		header = strings.TrimSpace(header)
+		return header != "" && !isTotalHeader(header)
+	}
+	return c.isDepartment(header)
+}
+
+// isTotalHeader reports whether a header is a statement-wide total rather than a department
+func isTotalHeader(header string) bool {
+	lower := strings.ToLower(strings.TrimSpace(header))
+	return lower == "total" || strings.HasPrefix(lower, "total ")
+}
+
+// departmentPath splits a NetSuite department such as "R&D : Platform" into its levels
+func departmentPath(name string) []string {
+	parts := strings.Split(name, strings.TrimSpace(departmentSeparator))
+	path := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			path = append(path, part)
+		}
+	}
+	if len(path) == 0 {
+		return []string{strings.TrimSpace(name)}
+	}
+	return path
+}
+
+// buildDepartmentTree nests the departments by their "Parent : Child" names, in
+// the order given, and rolls the totals up. Parents without a column of their own
+// are added so their sub-departments still roll up.
+func buildDepartmentTree(names []string, totals map[string]float64) []*DepartmentNode {
+	var roots []*DepartmentNode
+	nodes := make(map[string]*DepartmentNode)
+
+	for _, name := range names {
+		path := departmentPath(name)
+		var parent *DepartmentNode
+		for depth := range path {
+			key := strings.ToLower(strings.Join(path[:depth+1], departmentSeparator))
+			node, ok := nodes[key]
+			if !ok {
+				node = &DepartmentNode{Name: strings.Join(path[:depth+1], departmentSeparator)}
+				nodes[key] = node
+				if parent == nil {
+					roots = append(roots, node)
+				} else {
+					parent.Children = append(parent.Children, node)
+				}
+			}
+			parent = node
+		}
+		parent.Name = name
+		parent.HasColumn = true
+		parent.Total = totals[name]
+	}
+
+	for _, root := range roots {
+		root.rollup()
+	}
+	return roots
+}
+
+// rollup sets the node's Rollup from its own total and its children's rollups
+func (n *DepartmentNode) rollup() float64 {
+	n.Rollup = n.Total
+	for _, child := range n.Children {
+		n.Rollup += child.rollup()
+	}
+	return n.Rollup
+}
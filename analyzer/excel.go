@@ -0,0 +1,149 @@
+package analyzer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// sheetOptions selects which worksheets of a workbook are read
+type sheetOptions struct {
+	// sheet is a worksheet name or 1-based index; empty means auto-detect
+	sheet string
+	// combine reads every recognizable worksheet instead of just one
+	combine bool
+}
+
+// sheetOptionsFromForm reads the "sheet" and "combineSheets" form fields
+func sheetOptionsFromForm(form url.Values) (sheetOptions, error) {
+	opts := sheetOptions{sheet: strings.TrimSpace(form.Get("sheet"))}
+
+	if value := strings.TrimSpace(form.Get("combineSheets")); value != "" {
+		combine, err := strconv.ParseBool(value)
+		if err != nil {
+			return opts, fmt.Errorf("combineSheets must be true or false")
+		}
+		opts.combine = combine
+	}
+	if opts.combine && opts.sheet != "" {
+		return opts, fmt.Errorf("sheet and combineSheets cannot be used together")
+	}
+
+	return opts, nil
+}
+
+// workbookSheet is a worksheet that has been read into memory
+type workbookSheet struct {
+	name string
+	rows [][]string
+}
+
+// openWorkbook reads an uploaded Excel file into memory
+func openWorkbook(r io.Reader) (*excelize.File, error) {
+	// Read the entire file into memory
+	buf := new(bytes.Buffer)
+	_, err := io.Copy(buf, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	// Open Excel file
+	f, err := excelize.OpenReader(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Excel file: %w", err)
+	}
+	return f, nil
+}
+
+// selectSheets returns the worksheets chosen by opts. Without an explicit
+// choice, the first sheet that recognize accepts is used (falling back to the
+// first sheet); with combine, every recognized sheet is returned.
+func selectSheets(f *excelize.File, opts sheetOptions, recognize func(rows [][]string) bool) ([]workbookSheet, error) {
+	names := f.GetSheetList()
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no sheets found in Excel file")
+	}
+
+	readSheet := func(name string) (workbookSheet, error) {
+		rows, err := f.GetRows(name)
+		if err != nil {
+			return workbookSheet{}, fmt.Errorf("failed to read rows from sheet %q: %w", name, err)
+		}
+		return workbookSheet{name: name, rows: rows}, nil
+	}
+
+	// An explicit sheet, by name or by 1-based position
+	if opts.sheet != "" {
+		for _, name := range names {
+			if strings.EqualFold(name, opts.sheet) {
+				sheet, err := readSheet(name)
+				return []workbookSheet{sheet}, err
+			}
+		}
+		if index, err := strconv.Atoi(opts.sheet); err == nil && index >= 1 && index <= len(names) {
+			sheet, err := readSheet(names[index-1])
+			return []workbookSheet{sheet}, err
+		}
+		return nil, fmt.Errorf("sheet %q not found (available: %s)", opts.sheet, strings.Join(names, ", "))
+	}
+
+	var sheets []workbookSheet
+	for _, name := range names {
+		sheet, err := readSheet(name)
+		if err != nil {
+			return nil, err
+		}
+		if !recognize(sheet.rows) {
+			continue
+		}
+		sheets = append(sheets, sheet)
+		if !opts.combine {
+			break
+		}
+	}
+
+	if len(sheets) == 0 {
+		if opts.combine {
+			return nil, fmt.Errorf("no sheet contains a recognizable header row")
+		}
+		// Nothing recognizable; keep the historical behavior of reading the first sheet
+		sheet, err := readSheet(names[0])
+		return []workbookSheet{sheet}, err
+	}
+	return sheets, nil
+}
+
+// readTable reads the rows of a small uploaded table, such as a budget, from a
+// CSV or Excel file. Excel tables are read from the first sheet recognize accepts.
+func readTable(data []byte, filename string, recognize func(rows [][]string) bool) ([][]string, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".xlsx", ".xls":
+		f, err := openWorkbook(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		sheets, err := selectSheets(f, sheetOptions{}, recognize)
+		if err != nil {
+			return nil, err
+		}
+		return sheets[0].rows, nil
+	case ".csv":
+		reader := csv.NewReader(bytes.NewReader(data))
+		reader.TrimLeadingSpace = true
+		reader.FieldsPerRecord = -1
+		rows, err := reader.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV: %w", err)
+		}
+		return rows, nil
+	}
+	return nil, fmt.Errorf("must be a CSV or Excel file (.csv, .xlsx or .xls)")
+}
@@ -0,0 +1,359 @@
+package analyzer
+
+import (
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Response formats selected by the "format" parameter or the Accept header
+const (
+	FormatJSON = "json"
+	FormatXLSX = "xlsx"
+	FormatPDF  = "pdf"
+)
+
+// Media types of the non-JSON response formats
+const (
+	xlsxContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	pdfContentType  = "application/pdf"
+)
+
+// formatContentTypes maps the downloadable formats to their media types
+var formatContentTypes = map[string]string{
+	FormatXLSX: xlsxContentType,
+	FormatPDF:  pdfContentType,
+}
+
+// plWorkbookSheet is the worksheet the P&L is written to
+const plWorkbookSheet = "P&L"
+
+// ResponseFormat reads the "format" parameter, falling back to the Accept
+// header, and defaults to JSON. Only the formats a handler supports are accepted.
+func ResponseFormat(form url.Values, accept string, supported ...string) (string, error) {
+	format := strings.ToLower(strings.TrimSpace(form.Get("format")))
+	if format != "" {
+		if format == FormatJSON || containsString(supported, format) {
+			return format, nil
+		}
+		return "", fmt.Errorf("unknown format %q (expected json or %s)", format, strings.Join(supported, " or "))
+	}
+
+	for _, value := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		for _, format := range supported {
+			if formatContentTypes[format] == mediaType {
+				return format, nil
+			}
+		}
+	}
+	return FormatJSON, nil
+}
+
+// WritePLWorkbook sends the report as an Excel workbook download. The workbook
+// is rendered before anything is sent, so on error the response is untouched.
+func WritePLWorkbook(w http.ResponseWriter, report *PLReport) error {
+	f, err := buildPLWorkbook(report)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", xlsxContentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="pl-report.xlsx"`)
+	w.Write(buf.Bytes())
+	return nil
+}
+
+// buildPLWorkbook lays the P&L out as a statement: revenue, COGS by
+// subcategory, gross profit and margin, OpEx by category and EBITDA, with
+// headcount and non-headcount columns. Subtotals are formulas over the rows
+// above them, so edits to the figures carry through.
+func buildPLWorkbook(report *PLReport) (*excelize.File, error) {
+	f := excelize.NewFile()
+	if err := f.SetSheetName(f.GetSheetName(0), plWorkbookSheet); err != nil {
+		f.Close()
+		return nil, err
+	}
+	sw, err := newPLSheetWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	sw.title("NetSuite P&L Report" + currencyCaption(report))
+	sw.columnHeaders("Line Item", "Headcount", "Non-Headcount", "Total")
+
+	revenue := sw.values("Revenue", sw.styles.subtotal, nil, nil, report.Revenue)
+	sw.blank()
+
+	cogs := report.COGS
+	if cogs == nil {
+		cogs = &PLCategory{Name: "COGS"}
+	}
+	sw.heading("Cost of Goods Sold")
+	cogsTotal := sw.category("Total COGS", cogs)
+	grossProfit := sw.formula("Gross Profit", sw.styles.subtotal, fmt.Sprintf("D%d-D%d", revenue, cogsTotal))
+	sw.formula("Gross Margin", sw.styles.percent, fmt.Sprintf("IF(D%d=0,0,D%d/D%d)", revenue, grossProfit, revenue))
+	sw.blank()
+
+	sw.heading("Operating Expenses")
+	var categoryTotals []int
+	for _, name := range opexCategoryOrder(report.OpEx) {
+		sw.heading(name)
+		categoryTotals = append(categoryTotals, sw.category("Total "+name, report.OpEx[name]))
+	}
+	totalOpEx := sw.sumOfRows("Total Operating Expenses", categoryTotals)
+	sw.blank()
+
+	sw.formula("EBITDA", sw.styles.grandTotal, fmt.Sprintf("D%d-D%d", grossProfit, totalOpEx))
+
+	if sw.err == nil {
+		sw.err = f.SetColWidth(plWorkbookSheet, "A", "A", 40)
+	}
+	if sw.err == nil {
+		sw.err = f.SetColWidth(plWorkbookSheet, "B", "D", 16)
+	}
+	if sw.err != nil {
+		f.Close()
+		return nil, sw.err
+	}
+	return f, nil
+}
+
+// currencyCaption names the reporting currency for export titles, as in
+// " (EUR)". Reports that are plain US dollars, as before currencies were read,
+// are left unlabeled.
+func currencyCaption(report *PLReport) string {
+	if report.Currency == "" || (report.Currency == defaultReportingCurrency && len(report.Conversions) == 0) {
+		return ""
+	}
+	return " (" + report.Currency + ")"
+}
+
+// opexCategoryOrder lists the OpEx categories with S&M, R&D and G&A first, as
+// on the web report, followed by any others alphabetically
+func opexCategoryOrder(opex map[string]*PLCategory) []string {
+	var names []string
+	for _, name := range []string{"S&M", "R&D", "G&A"} {
+		if _, ok := opex[name]; ok {
+			names = append(names, name)
+		}
+	}
+	var others []string
+	for name := range opex {
+		if name != "S&M" && name != "R&D" && name != "G&A" {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+	return append(names, others...)
+}
+
+// plStyles are the cell styles of the P&L workbook
+type plStyles struct {
+	title      int
+	header     int
+	heading    int
+	subLabel   int
+	amount     int
+	subtotal   int
+	total      int
+	grandTotal int
+	percent    int
+}
+
+// plSheetWriter appends the rows of the P&L worksheet. The first error is kept
+// and later writes are skipped.
+type plSheetWriter struct {
+	f      *excelize.File
+	styles plStyles
+	row    int
+	err    error
+}
+
+// moneyFormat shows negatives in parentheses and zero as a dash
+const moneyFormat = `#,##0.00;(#,##0.00);"-"`
+
+// newPLSheetWriter registers the workbook styles and starts at the first row
+func newPLSheetWriter(f *excelize.File) (*plSheetWriter, error) {
+	money := moneyFormat
+	percent := "0.0%"
+	bold := &excelize.Font{Bold: true}
+	topBorder := []excelize.Border{{Type: "top", Color: "000000", Style: 1}}
+
+	sw := &plSheetWriter{f: f, row: 1}
+	styles := []struct {
+		id    *int
+		style *excelize.Style
+	}{
+		{&sw.styles.title, &excelize.Style{Font: &excelize.Font{Bold: true, Size: 14}}},
+		{&sw.styles.header, &excelize.Style{Font: bold, Border: []excelize.Border{{Type: "bottom", Color: "000000", Style: 1}}}},
+		{&sw.styles.heading, &excelize.Style{Font: bold}},
+		{&sw.styles.subLabel, &excelize.Style{Alignment: &excelize.Alignment{Indent: 1}}},
+		{&sw.styles.amount, &excelize.Style{CustomNumFmt: &money}},
+		{&sw.styles.subtotal, &excelize.Style{Font: bold, CustomNumFmt: &money}},
+		{&sw.styles.total, &excelize.Style{Font: bold, CustomNumFmt: &money, Border: topBorder}},
+		{&sw.styles.grandTotal, &excelize.Style{
+			Font:         bold,
+			CustomNumFmt: &money,
+			Border:       append(topBorder, excelize.Border{Type: "bottom", Color: "000000", Style: 6}),
+		}},
+		{&sw.styles.percent, &excelize.Style{Font: &excelize.Font{Italic: true}, CustomNumFmt: &percent}},
+	}
+	for _, s := range styles {
+		id, err := f.NewStyle(s.style)
+		if err != nil {
+			return nil, err
+		}
+		*s.id = id
+	}
+	return sw, nil
+}
+
+// cell writes a value and style to a cell of the current row
+func (sw *plSheetWriter) cell(col string, value interface{}, style int) {
+	if sw.err != nil {
+		return
+	}
+	ref := fmt.Sprintf("%s%d", col, sw.row)
+	if value != nil {
+		if sw.err = sw.f.SetCellValue(plWorkbookSheet, ref, value); sw.err != nil {
+			return
+		}
+	}
+	sw.err = sw.f.SetCellStyle(plWorkbookSheet, ref, ref, style)
+}
+
+// formulaCell writes a formula and style to a cell of the current row
+func (sw *plSheetWriter) formulaCell(col, formula string, style int) {
+	if sw.err != nil {
+		return
+	}
+	ref := fmt.Sprintf("%s%d", col, sw.row)
+	if sw.err = sw.f.SetCellFormula(plWorkbookSheet, ref, formula); sw.err != nil {
+		return
+	}
+	sw.err = sw.f.SetCellStyle(plWorkbookSheet, ref, ref, style)
+}
+
+// title writes the report title followed by a blank row
+func (sw *plSheetWriter) title(text string) {
+	sw.cell("A", text, sw.styles.title)
+	sw.row += 2
+}
+
+// columnHeaders writes the column captions, starting in column A
+func (sw *plSheetWriter) columnHeaders(headers ...string) {
+	for i, header := range headers {
+		sw.cell(string(rune('A'+i)), header, sw.styles.header)
+	}
+	sw.row++
+}
+
+// heading writes a section heading
+func (sw *plSheetWriter) heading(text string) {
+	sw.cell("A", text, sw.styles.heading)
+	sw.row++
+}
+
+// blank leaves an empty row
+func (sw *plSheetWriter) blank() {
+	sw.row++
+}
+
+// values writes a line with optional headcount and non-headcount amounts and
+// returns its row
+func (sw *plSheetWriter) values(label string, style int, headcount, nonHeadcount *float64, total float64) int {
+	sw.cell("A", label, sw.styles.heading)
+	if headcount != nil {
+		sw.cell("B", *headcount, style)
+	}
+	if nonHeadcount != nil {
+		sw.cell("C", *nonHeadcount, style)
+	}
+	sw.cell("D", total, style)
+	sw.row++
+	return sw.row - 1
+}
+
+// formula writes a line whose total is a formula and returns its row
+func (sw *plSheetWriter) formula(label string, style int, formula string) int {
+	sw.cell("A", label, sw.styles.heading)
+	sw.formulaCell("D", formula, style)
+	sw.row++
+	return sw.row - 1
+}
+
+// sortedSubcategories lists a category's subcategories, largest first
+func sortedSubcategories(cat *PLCategory) []*PLSubcategory {
+	subcats := make([]*PLSubcategory, 0, len(cat.Subcategories))
+	for _, subcat := range cat.Subcategories {
+		subcats = append(subcats, subcat)
+	}
+	sort.Slice(subcats, func(i, j int) bool {
+		if math.Abs(subcats[i].Total) != math.Abs(subcats[j].Total) {
+			return math.Abs(subcats[i].Total) > math.Abs(subcats[j].Total)
+		}
+		return subcats[i].Name < subcats[j].Name
+	})
+	return subcats
+}
+
+// category writes a category's subcategories, largest first, followed by a
+// total row summing them, and returns the total row
+func (sw *plSheetWriter) category(totalLabel string, cat *PLCategory) int {
+	subcats := sortedSubcategories(cat)
+	if len(subcats) == 0 {
+		return sw.values(totalLabel, sw.styles.total, &cat.Headcount, &cat.NonHeadcount, cat.Total)
+	}
+
+	first := sw.row
+	for _, subcat := range subcats {
+		sw.cell("A", subcat.Name, sw.styles.subLabel)
+		sw.cell("B", subcat.Headcount, sw.styles.amount)
+		sw.cell("C", subcat.NonHeadcount, sw.styles.amount)
+		sw.formulaCell("D", fmt.Sprintf("B%d+C%d", sw.row, sw.row), sw.styles.amount)
+		sw.row++
+	}
+	last := sw.row - 1
+
+	sw.cell("A", totalLabel, sw.styles.heading)
+	for _, col := range []string{"B", "C", "D"} {
+		sw.formulaCell(col, fmt.Sprintf("SUM(%s%d:%s%d)", col, first, col, last), sw.styles.total)
+	}
+	sw.row++
+	return sw.row - 1
+}
+
+// sumOfRows writes a total row adding up earlier total rows and returns its row
+func (sw *plSheetWriter) sumOfRows(label string, rows []int) int {
+	if len(rows) == 0 {
+		zero := 0.0
+		return sw.values(label, sw.styles.total, &zero, &zero, 0)
+	}
+
+	sw.cell("A", label, sw.styles.heading)
+	for _, col := range []string{"B", "C", "D"} {
+		terms := make([]string, len(rows))
+		for i, row := range rows {
+			terms[i] = fmt.Sprintf("%s%d", col, row)
+		}
+		sw.formulaCell(col, strings.Join(terms, "+"), sw.styles.total)
+	}
+	sw.row++
+	return sw.row - 1
+}
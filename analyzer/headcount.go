@@ -0,0 +1,136 @@
+package analyzer
+
+import "strings"
+
+// Quarterly report modes selected by the "mode" parameter
+const (
+	QuarterlyModeStatement = ""
+	QuarterlyModeHC        = "hc"
+)
+
+// HCAnalysis splits each department's expenses into headcount and non-headcount
+// costs, using the same account mapping rules as /api/analyze
+type HCAnalysis struct {
+	Departments  []*HCDepartment `json:"departments"`
+	Headcount    float64         `json:"headcount"`
+	NonHeadcount float64         `json:"nonHeadcount"`
+	Total        float64         `json:"total"`
+}
+
+// HCDepartment is the headcount split of a single department
+type HCDepartment struct {
+	Department   string       `json:"department"`
+	Headcount    float64      `json:"headcount"`
+	NonHeadcount float64      `json:"nonHeadcount"`
+	Total        float64      `json:"total"`
+	Lines        []HCLineItem `json:"lines"`
+}
+
+// HCLineItem is an expense line and how it was classified
+type HCLineItem struct {
+	Row       int     `json:"row"`
+	Label     string  `json:"label"`
+	Amount    float64 `json:"amount"`
+	Headcount bool    `json:"headcount"`
+}
+
+// Statement sections an expense line can sit in
+const (
+	sectionNone = iota
+	sectionIncome
+	sectionExpense
+)
+
+// AnalyzeHeadcount classifies the expense lines of every department. Lines are
+// the leaves of the department's line tree. A line counts as an expense when it
+// sits in an expense section of the statement ("Expense", "Cost of Sales"), or,
+// outside any section, when the mapping puts it in COGS or OpEx. The mapping's
+// headcount flag then decides the split.
+func AnalyzeHeadcount(report *QuarterlyReport, mapping *AccountMapping) *HCAnalysis {
+	analysis := &HCAnalysis{Departments: []*HCDepartment{}}
+
+	for _, name := range departmentOrder(report.DepartmentTree) {
+		deptData, ok := report.Departments[name]
+		if !ok {
+			continue
+		}
+
+		dept := &HCDepartment{Department: name, Lines: []HCLineItem{}}
+		var walk func(nodes []*LineItemNode, section int)
+		walk = func(nodes []*LineItemNode, section int) {
+			for _, node := range nodes {
+				if isSummaryLine(node.Label) {
+					continue
+				}
+				if len(node.Children) > 0 {
+					walk(node.Children, sectionOf(node.Label, section))
+					continue
+				}
+				if node.Amount == 0 {
+					continue
+				}
+
+				trans := Transaction{Account: node.Label, Department: name, Amount: node.Amount}
+				trans.AccountNumber, trans.AccountName = splitAccount(node.Label)
+				c := mapping.classify(trans)
+				switch sectionOf(node.Label, section) {
+				case sectionIncome:
+					continue
+				case sectionNone:
+					if c.Bucket != bucketCOGS && c.Bucket != bucketOpEx {
+						continue
+					}
+				}
+
+				dept.Lines = append(dept.Lines, HCLineItem{
+					Row:       node.Row,
+					Label:     node.Label,
+					Amount:    node.Amount,
+					Headcount: c.Headcount,
+				})
+				if c.Headcount {
+					dept.Headcount += node.Amount
+				} else {
+					dept.NonHeadcount += node.Amount
+				}
+			}
+		}
+		walk(deptData.Tree, sectionNone)
+		dept.Total = dept.Headcount + dept.NonHeadcount
+
+		analysis.Departments = append(analysis.Departments, dept)
+		analysis.Headcount += dept.Headcount
+		analysis.NonHeadcount += dept.NonHeadcount
+	}
+	analysis.Total = analysis.Headcount + analysis.NonHeadcount
+
+	return analysis
+}
+
+// sectionOf decides whether a statement heading opens an income or expense
+// section, keeping the enclosing section for other lines
+func sectionOf(label string, enclosing int) int {
+	lower := strings.ToLower(label)
+	income := strings.Contains(lower, "income") || strings.Contains(lower, "revenue")
+	expense := strings.Contains(lower, "expense") || strings.Contains(lower, "cost")
+	switch {
+	case income && !expense:
+		return sectionIncome
+	case expense && !income:
+		return sectionExpense
+	}
+	return enclosing
+}
+
+// departmentOrder lists the departments that have a column, parents before
+// their sub-departments, in sheet order
+func departmentOrder(nodes []*DepartmentNode) []string {
+	var names []string
+	for _, node := range nodes {
+		if node.HasColumn {
+			names = append(names, node.Name)
+		}
+		names = append(names, departmentOrder(node.Children)...)
+	}
+	return names
+}
@@ -0,0 +1,271 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// QuarterlyLayout locates the parts of a quarterly income statement. Rows are
+// 1-based as in Excel; fields left at zero are detected from the sheet content.
+type QuarterlyLayout struct {
+	DepartmentRow int    `json:"departmentRow,omitempty"`
+	SubHeaderRow  int    `json:"subHeaderRow,omitempty"`
+	DataRow       int    `json:"dataRow,omitempty"`
+	LabelColumn   string `json:"labelColumn,omitempty"`
+}
+
+// QuarterlyLayoutFromForm reads the optional "layout" form field, a JSON
+// QuarterlyLayout for statements that detection does not handle
+func QuarterlyLayoutFromForm(form url.Values) (QuarterlyLayout, error) {
+	var layout QuarterlyLayout
+	value := strings.TrimSpace(form.Get("layout"))
+	if value == "" {
+		return layout, nil
+	}
+
+	if err := json.Unmarshal([]byte(value), &layout); err != nil {
+		return layout, fmt.Errorf("layout must be a JSON object with departmentRow, subHeaderRow, dataRow or labelColumn")
+	}
+	if layout.DepartmentRow < 0 || layout.SubHeaderRow < 0 || layout.DataRow < 0 {
+		return layout, fmt.Errorf("layout rows must be positive row numbers")
+	}
+	if layout.LabelColumn != "" {
+		if _, err := excelize.ColumnNameToNumber(layout.LabelColumn); err != nil {
+			return layout, fmt.Errorf("invalid labelColumn %q (expected a column letter such as A)", layout.LabelColumn)
+		}
+		layout.LabelColumn = strings.ToUpper(layout.LabelColumn)
+	}
+	return layout, nil
+}
+
+// statementLayout is a QuarterlyLayout resolved to 0-based row and column indices
+type statementLayout struct {
+	departmentRow int
+	// subHeaderRows are the header rows between the department row and the data, if any
+	subHeaderRows []int
+	dataRow       int
+	// labelCol is the line item column, or -1 to use the first non-empty of columns A and B
+	labelCol int
+}
+
+// label returns the line item label of a data row
+func (sl statementLayout) label(row []string) string {
+	_, raw := sl.labelCell(row)
+	return strings.TrimSpace(raw)
+}
+
+// labelCell returns the column and untrimmed text of a data row's label
+func (sl statementLayout) labelCell(row []string) (int, string) {
+	if sl.labelCol >= 0 {
+		if sl.labelCol < len(row) {
+			return sl.labelCol, row[sl.labelCol]
+		}
+		return sl.labelCol, ""
+	}
+
+	if len(row) > 0 && strings.TrimSpace(row[0]) != "" {
+		return 0, row[0]
+	}
+	if len(row) > 1 {
+		return 1, row[1]
+	}
+	return 0, ""
+}
+
+// firstValueCol is the first column that can hold department headers or amounts
+func (sl statementLayout) firstValueCol() int {
+	if sl.labelCol >= 0 {
+		return sl.labelCol + 1
+	}
+	return 2
+}
+
+// describe converts the resolved layout back to 1-based rows for reporting
+func (sl statementLayout) describe() QuarterlyLayout {
+	layout := QuarterlyLayout{
+		DepartmentRow: sl.departmentRow + 1,
+		DataRow:       sl.dataRow + 1,
+	}
+	if len(sl.subHeaderRows) > 0 {
+		layout.SubHeaderRow = sl.subHeaderRows[0] + 1
+	}
+	if sl.labelCol >= 0 {
+		layout.LabelColumn, _ = excelize.ColumnNumberToName(sl.labelCol + 1)
+	}
+	return layout
+}
+
+// newStatementLayout starts a layout with the caller's label column, if any
+func newStatementLayout(given QuarterlyLayout) statementLayout {
+	sl := statementLayout{labelCol: -1}
+	if given.LabelColumn != "" {
+		col, _ := excelize.ColumnNameToNumber(given.LabelColumn)
+		sl.labelCol = col - 1
+	}
+	return sl
+}
+
+// detectStatementLayout finds the department header row, the sub-header rows
+// and the first data row by content. Rows given in the caller's layout are used
+// as-is and only the remaining ones are detected.
+func detectStatementLayout(rows [][]string, given QuarterlyLayout, departments *DepartmentConfig) (statementLayout, error) {
+	sl := newStatementLayout(given)
+
+	checkRow := func(name string, row int) error {
+		if row > len(rows) {
+			return fmt.Errorf("layout %s %d is beyond the last row (%d)", name, row, len(rows))
+		}
+		return nil
+	}
+
+	// Department headers
+	if given.DepartmentRow > 0 {
+		if err := checkRow("departmentRow", given.DepartmentRow); err != nil {
+			return sl, err
+		}
+		sl.departmentRow = given.DepartmentRow - 1
+	} else {
+		sl.departmentRow = findDepartmentRow(rows, departments, sl.firstValueCol())
+		if sl.departmentRow < 0 {
+			return sl, fmt.Errorf("no department header row found in the first %d rows", headerScanRows)
+		}
+	}
+
+	// Sub-headers such as month names, "Total" and "Amount" sit between the
+	// department row and the first row of figures
+	fromCol := sl.firstValueCol()
+	next := sl.departmentRow + 1
+	if given.SubHeaderRow > 0 {
+		if err := checkRow("subHeaderRow", given.SubHeaderRow); err != nil {
+			return sl, err
+		}
+		if given.SubHeaderRow-1 <= sl.departmentRow {
+			return sl, fmt.Errorf("layout subHeaderRow must be below the department row")
+		}
+		sl.subHeaderRows = append(sl.subHeaderRows, given.SubHeaderRow-1)
+		next = given.SubHeaderRow
+	}
+
+	if given.DataRow > 0 {
+		if err := checkRow("dataRow", given.DataRow); err != nil {
+			return sl, err
+		}
+		if given.DataRow-1 < next {
+			return sl, fmt.Errorf("layout dataRow must be below the header rows")
+		}
+		sl.dataRow = given.DataRow - 1
+		for i := next; i < sl.dataRow; i++ {
+			if isSubHeaderRow(rows[i], fromCol) {
+				sl.subHeaderRows = append(sl.subHeaderRows, i)
+			}
+		}
+		return sl, nil
+	}
+
+	sl.dataRow = len(rows)
+	for i := next; i < len(rows); i++ {
+		if isBlankRecord(rows[i]) {
+			continue
+		}
+		if isSubHeaderRow(rows[i], fromCol) {
+			sl.subHeaderRows = append(sl.subHeaderRows, i)
+			continue
+		}
+		sl.dataRow = i
+		break
+	}
+	if sl.dataRow >= len(rows) {
+		return sl, fmt.Errorf("no data rows found below the department headers (row %d)", sl.departmentRow+1)
+	}
+	return sl, nil
+}
+
+// findDepartmentRow returns the index of the row among the first headerScanRows
+// that names the most departments in the columns from fromCol on, or -1
+func findDepartmentRow(rows [][]string, departments *DepartmentConfig, fromCol int) int {
+	best, bestCount := -1, 0
+	for i := 0; i < headerScanRows && i < len(rows); i++ {
+		count := 0
+		for j := fromCol; j < len(rows[i]); j++ {
+			if isDepartmentCaption(rows[i][j], departments) {
+				count++
+			}
+		}
+		if count > bestCount {
+			best, bestCount = i, count
+		}
+	}
+	return best
+}
+
+// isDepartmentCaption reports whether a header cell names a department. With
+// DiscoverAll any text counts except the captions of the sub-header rows:
+// totals, months and "Amount".
+func isDepartmentCaption(cell string, departments *DepartmentConfig) bool {
+	cell = strings.TrimSpace(cell)
+	if !departments.acceptsHeader(cell) || isQuarterlyNumber(cell) {
+		return false
+	}
+	if departments == nil || !departments.DiscoverAll {
+		return true
+	}
+	if _, ok := parseMonthHeader(cell); ok {
+		return false
+	}
+	return !strings.EqualFold(cell, "amount")
+}
+
+// isSubHeaderRow reports whether a row holds column captions rather than
+// figures: it has text in the amount columns but no numbers
+func isSubHeaderRow(row []string, fromCol int) bool {
+	hasText := false
+	for j := fromCol; j < len(row); j++ {
+		cell := strings.TrimSpace(row[j])
+		if cell == "" || cell == "-" {
+			continue
+		}
+		if isQuarterlyNumber(cell) {
+			return false
+		}
+		hasText = true
+	}
+	return hasText
+}
+
+// isQuarterlyNumber reports whether a cell holds an amount such as "1,234.50" or "($500)"
+func isQuarterlyNumber(cell string) bool {
+	s := strings.NewReplacer(",", "", "$", "", "(", "", ")", "").Replace(strings.TrimSpace(cell))
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+// periodPattern matches statement period captions such as "Q1 2025", "FY2025" or "Jan 2025 - Mar 2025"
+var periodPattern = regexp.MustCompile(`(?i)\b(q[1-4]|fy\s?\d{2,4}|(19|20)\d{2})\b`)
+
+// findStatementTitle reads the company name and period from the title block
+// above the department headers
+func findStatementTitle(rows [][]string, departmentRow int) (string, string) {
+	var companyName, period string
+	for i := 0; i < departmentRow && i < len(rows); i++ {
+		for _, cell := range rows[i] {
+			cell = strings.TrimSpace(cell)
+			if cell == "" {
+				continue
+			}
+			if companyName == "" && (strings.Contains(cell, "Inc") || strings.Contains(cell, "LLC") || strings.Contains(cell, "Corp")) {
+				companyName = cell
+				continue
+			}
+			if period == "" && periodPattern.MatchString(cell) {
+				period = cell
+			}
+		}
+	}
+	return companyName, period
+}
@@ -0,0 +1,118 @@
+package analyzer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDetectStatementLayout(t *testing.T) {
+	// netsuiteRows is the classic NetSuite export: a title block, the
+	// department row on row 7, sub-headers on row 8 and data from row 10
+	netsuiteRows := [][]string{
+		{"Acme Corp"},
+		{"Income Statement"},
+		{},
+		{"Q1 2025"},
+		{},
+		{},
+		{"", "", "G&A", "", "R&D", ""},
+		{"", "", "Jan 2025", "Total", "Jan 2025", "Total"},
+		{},
+		{"4000 - Revenue", "", "1", "1", "2", "2"},
+	}
+
+	tests := []struct {
+		name        string
+		rows        [][]string
+		given       QuarterlyLayout
+		departments *DepartmentConfig
+		want        statementLayout
+		wantErr     bool
+	}{
+		{
+			name: "NetSuite title block",
+			rows: netsuiteRows,
+			want: statementLayout{departmentRow: 6, subHeaderRows: []int{7}, dataRow: 9, labelCol: -1},
+		},
+		{
+			name: "no title block or sub-headers",
+			rows: [][]string{
+				{"", "", "Marketing", "Sales"},
+				{"6100 - Salaries", "", "(1,200.00)", "$300"},
+			},
+			want: statementLayout{departmentRow: 0, dataRow: 1, labelCol: -1},
+		},
+		{
+			name: "two sub-header rows",
+			rows: [][]string{
+				{"", "", "R&D", ""},
+				{"", "", "Jan 2025", "Total"},
+				{"", "", "Amount", "Amount"},
+				{"4000 - Revenue", "", "1", "1"},
+			},
+			want: statementLayout{departmentRow: 0, subHeaderRows: []int{1, 2}, dataRow: 3, labelCol: -1},
+		},
+		{
+			name: "configured department",
+			rows: [][]string{
+				{"Acme Corp"},
+				{"", "", "Customer Success", "Total"},
+				{"4000 - Revenue", "", "1", "1"},
+			},
+			departments: &DepartmentConfig{Departments: []DepartmentRule{{Name: "Customer Success"}}},
+			want:        statementLayout{departmentRow: 1, dataRow: 2, labelCol: -1},
+		},
+		{
+			name:  "given rows and label column",
+			rows:  netsuiteRows,
+			given: QuarterlyLayout{DepartmentRow: 7, DataRow: 10, LabelColumn: "A"},
+			want:  statementLayout{departmentRow: 6, subHeaderRows: []int{7}, dataRow: 9, labelCol: 0},
+		},
+		{
+			name:  "given sub-header row",
+			rows:  netsuiteRows,
+			given: QuarterlyLayout{SubHeaderRow: 8},
+			want:  statementLayout{departmentRow: 6, subHeaderRows: []int{7}, dataRow: 9, labelCol: -1},
+		},
+		{
+			name:    "no department row",
+			rows:    [][]string{{"Acme Corp"}, {"4000 - Revenue", "", "1"}},
+			wantErr: true,
+		},
+		{
+			name:    "given row beyond the sheet",
+			rows:    netsuiteRows,
+			given:   QuarterlyLayout{DepartmentRow: 20},
+			wantErr: true,
+		},
+		{
+			name:    "sub-header row above the department row",
+			rows:    netsuiteRows,
+			given:   QuarterlyLayout{DepartmentRow: 7, SubHeaderRow: 5},
+			wantErr: true,
+		},
+		{
+			name:    "no data below the headers",
+			rows:    netsuiteRows[:8],
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := detectStatementLayout(tt.rows, tt.given, tt.departments)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got layout %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
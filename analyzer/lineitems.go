@@ -0,0 +1,187 @@
+package analyzer
+
+import (
+	"math"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// LineItemNode is a statement line with the lines nested under it. Amount is
+// the line's own figure; Total rolls up Amount and the children. When the
+// statement has a "Total - ..." row for the line, ReportedTotal holds it and
+// Difference is how far the rollup is from it.
+type LineItemNode struct {
+	Row           int             `json:"row"`
+	Label         string          `json:"label"`
+	AccountCode   string          `json:"accountCode,omitempty"`
+	Amount        float64         `json:"amount"`
+	Total         float64         `json:"total"`
+	ReportedTotal *float64        `json:"reportedTotal,omitempty"`
+	Difference    float64         `json:"difference,omitempty"`
+	Children      []*LineItemNode `json:"children,omitempty"`
+}
+
+// balanceTolerance absorbs rounding in the statement's own totals
+const balanceTolerance = 0.005
+
+// statementLine is a data row of the statement, before amounts are read
+type statementLine struct {
+	rowIdx int
+	label  string
+	indent int
+}
+
+// lineNode is the shape of the statement tree, shared by every department
+type lineNode struct {
+	line     statementLine
+	code     string
+	totalRow int
+	section  bool
+	summary  bool
+	children []*lineNode
+}
+
+// lineIndent measures how far a label is indented, from leading spaces and
+// from the cell's alignment indent, which NetSuite uses in Excel exports
+func lineIndent(f *excelize.File, sheet string, rowIdx, col int, raw string) int {
+	indent := len(raw) - len(strings.TrimLeft(raw, " \t\u00a0"))
+	cell, err := excelize.CoordinatesToCellName(col+1, rowIdx+1)
+	if err != nil {
+		return indent
+	}
+	if styleID, err := f.GetCellStyle(sheet, cell); err == nil && styleID != 0 {
+		if style, err := f.GetStyle(styleID); err == nil && style.Alignment != nil {
+			indent += style.Alignment.Indent * 4
+		}
+	}
+	return indent
+}
+
+// totalOf returns the label closed by a "Total - ..." row
+func totalOf(label string) (string, bool) {
+	const prefix = "total - "
+	if !strings.HasPrefix(strings.ToLower(label), prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(label[len(prefix):]), true
+}
+
+// isSummaryLine reports whether a line is a computed subtotal such as
+// "Net Income" or "Gross Profit" rather than a line of the statement
+func isSummaryLine(label string) bool {
+	lower := strings.ToLower(strings.TrimSpace(label))
+	return strings.HasPrefix(lower, "net ") || strings.HasPrefix(lower, "gross profit")
+}
+
+// buildLineTree reconstructs the account hierarchy from the statement's lines.
+// A line opens a section when a matching "Total - ..." row follows it; other
+// lines nest under the line above when they are indented further or when their
+// account code falls under its code (61100 under 61000 under 60000).
+func buildLineTree(lines []statementLine) []*lineNode {
+	// Sections are the labels that a later Total row closes
+	closers := make(map[string]int)
+	for _, line := range lines {
+		if label, ok := totalOf(line.label); ok {
+			closers[strings.ToLower(label)]++
+		}
+	}
+
+	var roots []*lineNode
+	var stack []*lineNode
+	for _, line := range lines {
+		// A Total row closes the nearest open section with its label; it is
+		// never a line of its own, so unmatched Total rows are left out
+		if label, ok := totalOf(line.label); ok {
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i].section && strings.EqualFold(stack[i].line.label, label) {
+					stack[i].totalRow = line.rowIdx
+					stack = stack[:i]
+					break
+				}
+			}
+			continue
+		}
+
+		node := &lineNode{line: line, totalRow: -1, summary: isSummaryLine(line.label)}
+		node.code, _ = splitAccount(line.label)
+		if closers[strings.ToLower(line.label)] > 0 {
+			node.section = true
+			closers[strings.ToLower(line.label)]--
+		}
+
+		// Computed lines sit at the top level without closing open sections
+		if node.summary {
+			roots = append(roots, node)
+			continue
+		}
+
+		for len(stack) > 0 && !stack[len(stack)-1].isParentOf(node) {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			roots = append(roots, node)
+		} else {
+			parent := stack[len(stack)-1]
+			parent.children = append(parent.children, node)
+		}
+		stack = append(stack, node)
+	}
+	return roots
+}
+
+// isParentOf decides whether a line belongs under this one
+func (n *lineNode) isParentOf(child *lineNode) bool {
+	if n.section {
+		return true
+	}
+	if child.line.indent > n.line.indent {
+		return true
+	}
+	if n.code != "" && child.code != "" && child.code != n.code && len(child.code) == len(n.code) {
+		prefix := strings.TrimRight(n.code, "0")
+		return prefix != "" && strings.HasPrefix(child.code, prefix)
+	}
+	return false
+}
+
+// materialize fills in a department's amounts, read by amountAt from the
+// statement row, and rolls them up the tree
+func (n *lineNode) materialize(amountAt func(rowIdx int) float64) *LineItemNode {
+	node := &LineItemNode{
+		Row:         n.line.rowIdx + 1,
+		Label:       n.line.label,
+		AccountCode: n.code,
+		Amount:      amountAt(n.line.rowIdx),
+	}
+	node.Total = node.Amount
+	for _, child := range n.children {
+		childNode := child.materialize(amountAt)
+		node.Children = append(node.Children, childNode)
+		node.Total += childNode.Total
+	}
+
+	if n.totalRow >= 0 {
+		reported := amountAt(n.totalRow)
+		node.ReportedTotal = &reported
+		if diff := reported - node.Total; math.Abs(diff) > balanceTolerance {
+			node.Difference = diff
+		}
+	}
+	return node
+}
+
+// materializeTree builds a department's tree and returns it with the sum of
+// its top-level lines, leaving out Total rows and computed lines such as Net Income
+func materializeTree(roots []*lineNode, amountAt func(rowIdx int) float64) ([]*LineItemNode, float64) {
+	var tree []*LineItemNode
+	var total float64
+	for _, root := range roots {
+		node := root.materialize(amountAt)
+		tree = append(tree, node)
+		if !root.summary {
+			total += node.Total
+		}
+	}
+	return tree, total
+}
@@ -0,0 +1,177 @@
+package analyzer
+
+import (
+	"strings"
+	"testing"
+)
+
+// describeTree renders a tree as "Label[Child,Child]" to compare its shape
+func describeTree(nodes []*LineItemNode) string {
+	var parts []string
+	for _, node := range nodes {
+		part := node.Label
+		if len(node.Children) > 0 {
+			part += "[" + describeTree(node.Children) + "]"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ",")
+}
+
+// findNode returns the first node with the label, depth first
+func findNode(nodes []*LineItemNode, label string) *LineItemNode {
+	for _, node := range nodes {
+		if node.Label == label {
+			return node
+		}
+		if found := findNode(node.Children, label); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// treeLine is a statement line with its indent and amount
+type treeLine struct {
+	label  string
+	indent int
+	amount float64
+}
+
+// nodeCheck is the expected rollup of one node; reported is nil when the
+// statement has no Total row for it
+type nodeCheck struct {
+	label      string
+	total      float64
+	reported   *float64
+	difference float64
+}
+
+func reportedAmount(v float64) *float64 {
+	return &v
+}
+
+func TestBuildLineTree(t *testing.T) {
+	tests := []struct {
+		name   string
+		lines  []treeLine
+		tree   string
+		total  float64
+		checks []nodeCheck
+	}{
+		{
+			name: "account codes nest under their parent codes",
+			lines: []treeLine{
+				{label: "60000 - Operating expenses"},
+				{label: "61000 - Payroll"},
+				{label: "61100 - Salaries", amount: 100},
+				{label: "61200 - Benefits", amount: 50},
+				{label: "62000 - Software", amount: 30},
+				{label: "70000 - Other expense", amount: 5},
+			},
+			tree:  "60000 - Operating expenses[61000 - Payroll[61100 - Salaries,61200 - Benefits],62000 - Software],70000 - Other expense",
+			total: 185,
+			checks: []nodeCheck{
+				{label: "60000 - Operating expenses", total: 180},
+				{label: "61000 - Payroll", total: 150},
+			},
+		},
+		{
+			name: "indented lines nest under the line above",
+			lines: []treeLine{
+				{label: "Travel", amount: 10},
+				{label: "Flights", indent: 4, amount: 20},
+				{label: "Hotels", indent: 4, amount: 30},
+				{label: "Office", amount: 40},
+			},
+			tree:   "Travel[Flights,Hotels],Office",
+			total:  100,
+			checks: []nodeCheck{{label: "Travel", total: 60}},
+		},
+		{
+			name: "Total rows close sections and report their total",
+			lines: []treeLine{
+				{label: "Income"},
+				{label: "4000 - Revenue", amount: 100},
+				{label: "Other income", amount: 50},
+				{label: "Total - Income", amount: 150},
+				{label: "Expenses"},
+				{label: "6100 - Salaries", amount: 80},
+				{label: "total - expenses", amount: 90},
+				{label: "Net Income", amount: 60},
+			},
+			tree:  "Income[4000 - Revenue,Other income],Expenses[6100 - Salaries],Net Income",
+			total: 230,
+			checks: []nodeCheck{
+				{label: "Income", total: 150, reported: reportedAmount(150)},
+				{label: "Expenses", total: 80, reported: reportedAmount(90), difference: 10},
+				{label: "4000 - Revenue", total: 100},
+			},
+		},
+		{
+			name: "repeated sections close the nearest open one",
+			lines: []treeLine{
+				{label: "Other"},
+				{label: "Fees", amount: 1},
+				{label: "Total - Other", amount: 1},
+				{label: "Other"},
+				{label: "Interest", amount: 2},
+				{label: "Total - Other", amount: 2},
+			},
+			tree:  "Other[Fees],Other[Interest]",
+			total: 3,
+		},
+		{
+			name: "unmatched Total rows are left out",
+			lines: []treeLine{
+				{label: "Rent", amount: 10},
+				{label: "Total - Facilities", amount: 99},
+			},
+			tree:   "Rent",
+			total:  10,
+			checks: []nodeCheck{{label: "Rent", total: 10}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lines []statementLine
+			amounts := make(map[int]float64)
+			for i, line := range tt.lines {
+				lines = append(lines, statementLine{rowIdx: i, label: line.label, indent: line.indent})
+				amounts[i] = line.amount
+			}
+			tree, total := materializeTree(buildLineTree(lines), func(rowIdx int) float64 {
+				return amounts[rowIdx]
+			})
+
+			if got := describeTree(tree); got != tt.tree {
+				t.Errorf("tree = %s, want %s", got, tt.tree)
+			}
+			if total != tt.total {
+				t.Errorf("total = %v, want %v", total, tt.total)
+			}
+			for _, check := range tt.checks {
+				node := findNode(tree, check.label)
+				if node == nil {
+					t.Errorf("no node %q", check.label)
+					continue
+				}
+				if node.Total != check.total {
+					t.Errorf("%s total = %v, want %v", check.label, node.Total, check.total)
+				}
+				switch {
+				case check.reported == nil && node.ReportedTotal != nil:
+					t.Errorf("%s reported total = %v, want none", check.label, *node.ReportedTotal)
+				case check.reported != nil && node.ReportedTotal == nil:
+					t.Errorf("%s has no reported total, want %v", check.label, *check.reported)
+				case check.reported != nil && *node.ReportedTotal != *check.reported:
+					t.Errorf("%s reported total = %v, want %v", check.label, *node.ReportedTotal, *check.reported)
+				}
+				if node.Difference != check.difference {
+					t.Errorf("%s difference = %v, want %v", check.label, node.Difference, check.difference)
+				}
+			}
+		})
+	}
+}
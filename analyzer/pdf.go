@@ -0,0 +1,550 @@
+package analyzer
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// Page geometry of the PDF board pack, in millimetres
+const (
+	pdfMargin       = 15
+	pdfRowHeight    = 6
+	pdfIndentWidth  = 4
+	pdfAmountWidth  = 32
+	pdfSummaryWidth = 27
+)
+
+// BoardPackTitle is printed at the top of every page of a board pack
+type BoardPackTitle struct {
+	company   string
+	statement string
+	period    string
+}
+
+// BoardPackTitleFromForm labels a board pack with the report's company and
+// period, which the "company" and "periodLabel" fields override
+func BoardPackTitleFromForm(form url.Values, company, period string) BoardPackTitle {
+	title := BoardPackTitle{company: company, period: period}
+	if value := strings.TrimSpace(form.Get("company")); value != "" {
+		title.company = value
+	}
+	if value := strings.TrimSpace(form.Get("periodLabel")); value != "" {
+		title.period = value
+	}
+	return title
+}
+
+// PLPeriodLabel describes the period a P&L covers from its period breakdown, if any
+func PLPeriodLabel(report *PLReport) string {
+	var first, last string
+	for _, period := range report.Periods {
+		if period.Period == undatedPeriod {
+			continue
+		}
+		if first == "" {
+			first = period.Period
+		}
+		last = period.Period
+	}
+	if first == last {
+		return first
+	}
+	return first + " to " + last
+}
+
+// WritePLBoardPack sends the P&L as a PDF download
+func WritePLBoardPack(w http.ResponseWriter, report *PLReport, title BoardPackTitle) {
+	title.statement = "Profit & Loss Statement" + currencyCaption(report)
+	bp := newBoardPack(title)
+	bp.plStatement(report)
+	if len(report.Periods) > 0 {
+		bp.plPeriods(report.Periods)
+	}
+	if len(report.Subsidiaries) > 0 {
+		bp.plSubsidiaries(report)
+	}
+	bp.send(w, "pl-report.pdf")
+}
+
+// WriteQuarterlyBoardPack sends the quarterly statement as a PDF download: a
+// summary of every department followed by each department's statement
+func WriteQuarterlyBoardPack(w http.ResponseWriter, report *QuarterlyReport, title BoardPackTitle) {
+	title.statement = "Quarterly Income Statement"
+	bp := newBoardPack(title)
+	names := departmentOrder(report.DepartmentTree)
+	bp.quarterlySummary(report, names)
+	if report.HC != nil {
+		bp.headcountSplit(report.HC)
+	}
+	for _, name := range names {
+		if deptData, ok := report.Departments[name]; ok {
+			bp.departmentStatement(deptData)
+		}
+	}
+	bp.send(w, "quarterly-income-statement.pdf")
+}
+
+// pdfRowStyle sets the weight and rules of a statement row
+type pdfRowStyle int
+
+const (
+	pdfRowLine pdfRowStyle = iota
+	pdfRowHeading
+	pdfRowSubtotal
+	pdfRowTotal
+	pdfRowGrandTotal
+)
+
+// boardPack lays statements out as paginated tables. Each table repeats its
+// column headers at the top of every page it spans.
+type boardPack struct {
+	pdf     *gofpdf.Fpdf
+	tr      func(string) string
+	columns []string
+	width   float64
+}
+
+// newBoardPack starts a Letter-size document with the title block and page
+// numbers on every page
+func newBoardPack(title BoardPackTitle) *boardPack {
+	pdf := gofpdf.New("P", "mm", "Letter", "")
+	pdf.SetMargins(pdfMargin, pdfMargin, pdfMargin)
+	pdf.SetAutoPageBreak(true, pdfMargin)
+	pdf.AliasNbPages("")
+	bp := &boardPack{pdf: pdf, tr: pdf.UnicodeTranslatorFromDescriptor("")}
+
+	heading, subheading := title.company, title.statement
+	if heading == "" {
+		heading, subheading = title.statement, ""
+	}
+	if title.period != "" {
+		if subheading != "" {
+			subheading += " - "
+		}
+		subheading += title.period
+	}
+
+	pdf.SetHeaderFunc(func() {
+		pdf.SetFont("Helvetica", "B", 14)
+		pdf.CellFormat(0, 7, bp.tr(heading), "", 1, "L", false, 0, "")
+		if subheading != "" {
+			pdf.SetFont("Helvetica", "", 10)
+			pdf.CellFormat(0, 5, bp.tr(subheading), "", 1, "L", false, 0, "")
+		}
+		pageWidth, _ := pdf.GetPageSize()
+		pdf.Line(pdfMargin, pdf.GetY()+1, pageWidth-pdfMargin, pdf.GetY()+1)
+		pdf.Ln(5)
+	})
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-pdfMargin + 3)
+		pdf.SetFont("Helvetica", "I", 8)
+		pdf.CellFormat(0, 5, fmt.Sprintf("Page %d of {nb}", pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+	return bp
+}
+
+// send writes the finished document as a download
+func (bp *boardPack) send(w http.ResponseWriter, filename string) {
+	var buf bytes.Buffer
+	if err := bp.pdf.Output(&buf); err != nil {
+		http.Error(w, "Failed to build PDF: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", pdfContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Write(buf.Bytes())
+}
+
+// table starts a table on a new page with a title and amount columns of the given width
+func (bp *boardPack) table(title string, width float64, columns ...string) {
+	bp.pdf.AddPage()
+	bp.pdf.SetFont("Helvetica", "B", 12)
+	bp.pdf.CellFormat(0, 8, bp.tr(title), "", 1, "L", false, 0, "")
+	bp.columns, bp.width = columns, width
+	bp.columnHeaders()
+}
+
+// continueTable starts a new table below the current one, on the same page when it fits
+func (bp *boardPack) continueTable(title string, width float64, columns ...string) {
+	bp.pdf.Ln(pdfRowHeight)
+	if bp.remaining() < 4*pdfRowHeight {
+		bp.table(title, width, columns...)
+		return
+	}
+	bp.pdf.SetFont("Helvetica", "B", 12)
+	bp.pdf.CellFormat(0, 8, bp.tr(title), "", 1, "L", false, 0, "")
+	bp.columns, bp.width = columns, width
+	bp.columnHeaders()
+}
+
+// columnHeaders writes the captions of the current table's amount columns
+func (bp *boardPack) columnHeaders() {
+	bp.pdf.SetFont("Helvetica", "B", 9)
+	bp.pdf.CellFormat(bp.labelWidth(), pdfRowHeight, "", "B", 0, "L", false, 0, "")
+	for _, column := range bp.columns {
+		bp.pdf.CellFormat(bp.width, pdfRowHeight, bp.tr(column), "B", 0, "R", false, 0, "")
+	}
+	bp.pdf.Ln(pdfRowHeight)
+}
+
+// labelWidth is the width left for line labels beside the amount columns
+func (bp *boardPack) labelWidth() float64 {
+	pageWidth, _ := bp.pdf.GetPageSize()
+	return pageWidth - 2*pdfMargin - float64(len(bp.columns))*bp.width
+}
+
+// remaining is the height left on the current page
+func (bp *boardPack) remaining() float64 {
+	_, pageHeight := bp.pdf.GetPageSize()
+	return pageHeight - pdfMargin - bp.pdf.GetY()
+}
+
+// row writes a statement line. Amounts are right-aligned in the table's
+// columns; empty strings leave a column blank.
+func (bp *boardPack) row(style pdfRowStyle, indent int, label string, amounts ...string) {
+	if bp.remaining() < pdfRowHeight {
+		bp.pdf.AddPage()
+		bp.columnHeaders()
+	}
+
+	fontStyle := ""
+	if style != pdfRowLine {
+		fontStyle = "B"
+	}
+	bp.pdf.SetFont("Helvetica", fontStyle, 9)
+
+	offset := float64(indent) * pdfIndentWidth
+	if offset > 0 {
+		bp.pdf.CellFormat(offset, pdfRowHeight, "", "", 0, "L", false, 0, "")
+	}
+	bp.pdf.CellFormat(bp.labelWidth()-offset, pdfRowHeight, bp.fit(label, bp.labelWidth()-offset), "", 0, "L", false, 0, "")
+
+	border := ""
+	if style == pdfRowTotal || style == pdfRowGrandTotal {
+		border = "T"
+	}
+	for i := range bp.columns {
+		amount := ""
+		if i < len(amounts) {
+			amount = amounts[i]
+		}
+		cellBorder := border
+		if amount == "" {
+			cellBorder = ""
+		}
+		bp.pdf.CellFormat(bp.width, pdfRowHeight, amount, cellBorder, 0, "R", false, 0, "")
+	}
+	bp.pdf.Ln(pdfRowHeight)
+
+	if style == pdfRowGrandTotal {
+		pageWidth, _ := bp.pdf.GetPageSize()
+		start := pageWidth - pdfMargin - float64(len(bp.columns))*bp.width
+		y := bp.pdf.GetY()
+		bp.pdf.Line(start, y, pageWidth-pdfMargin, y)
+		bp.pdf.Line(start, y+0.6, pageWidth-pdfMargin, y+0.6)
+	}
+}
+
+// fit shortens a label to the width available, translated for the PDF font
+func (bp *boardPack) fit(label string, width float64) string {
+	text := bp.tr(label)
+	if bp.pdf.GetStringWidth(text) <= width-2 {
+		return text
+	}
+	for len(text) > 0 && bp.pdf.GetStringWidth(text+"...") > width-2 {
+		text = text[:len(text)-1]
+	}
+	return text + "..."
+}
+
+// plStatement writes the P&L with headcount and non-headcount columns
+func (bp *boardPack) plStatement(report *PLReport) {
+	bp.table("Statement of Operations", pdfAmountWidth, "Headcount", "Non-Headcount", "Total")
+
+	bp.row(pdfRowSubtotal, 0, "Revenue", "", "", formatAccounting(report.Revenue))
+
+	cogs := report.COGS
+	if cogs == nil {
+		cogs = &PLCategory{Name: "COGS"}
+	}
+	bp.row(pdfRowHeading, 0, "Cost of Goods Sold")
+	bp.plCategory("Total COGS", cogs)
+	bp.row(pdfRowSubtotal, 0, "Gross Profit", "", "", formatAccounting(report.GrossProfit))
+	bp.row(pdfRowLine, 0, "Gross Margin", "", "", formatPercent(report.GrossMargin))
+
+	bp.row(pdfRowHeading, 0, "Operating Expenses")
+	var headcount, nonHeadcount float64
+	for _, name := range opexCategoryOrder(report.OpEx) {
+		cat := report.OpEx[name]
+		bp.row(pdfRowHeading, 1, name)
+		bp.plCategory("Total "+name, cat)
+		headcount += cat.Headcount
+		nonHeadcount += cat.NonHeadcount
+	}
+	bp.row(pdfRowTotal, 0, "Total Operating Expenses",
+		formatAccounting(headcount), formatAccounting(nonHeadcount), formatAccounting(report.TotalOpEx))
+
+	bp.row(pdfRowGrandTotal, 0, "EBITDA", "", "", formatAccounting(report.EBITDA))
+}
+
+// plCategory writes a category's subcategories and its total
+func (bp *boardPack) plCategory(totalLabel string, cat *PLCategory) {
+	for _, subcat := range sortedSubcategories(cat) {
+		bp.row(pdfRowLine, 2, subcat.Name,
+			formatAccounting(subcat.Headcount), formatAccounting(subcat.NonHeadcount), formatAccounting(subcat.Total))
+	}
+	bp.row(pdfRowTotal, 1, totalLabel,
+		formatAccounting(cat.Headcount), formatAccounting(cat.NonHeadcount), formatAccounting(cat.Total))
+}
+
+// plPeriods writes the key figures of each period side by side
+func (bp *boardPack) plPeriods(periods []*PeriodReport) {
+	bp.table("By Period", pdfSummaryWidth, "Revenue", "Gross Profit", "Gross Margin", "OpEx", "EBITDA")
+	for _, period := range periods {
+		bp.keyFigures(pdfRowLine, period.Period, period.Report)
+	}
+}
+
+// plSubsidiaries writes the key figures of each subsidiary, the intercompany
+// eliminations and the consolidated total
+func (bp *boardPack) plSubsidiaries(report *PLReport) {
+	bp.table("By Subsidiary", pdfSummaryWidth, "Revenue", "Gross Profit", "Gross Margin", "OpEx", "EBITDA")
+	var revenue, grossProfit, opex, ebitda float64
+	for _, subsidiary := range report.Subsidiaries {
+		bp.keyFigures(pdfRowLine, subsidiary.Subsidiary, subsidiary.Report)
+		revenue += subsidiary.Report.Revenue
+		grossProfit += subsidiary.Report.GrossProfit
+		opex += subsidiary.Report.TotalOpEx
+		ebitda += subsidiary.Report.EBITDA
+	}
+	if len(report.Eliminations) > 0 {
+		bp.row(pdfRowLine, 0, "Eliminations",
+			formatAccounting(report.Revenue-revenue),
+			formatAccounting(report.GrossProfit-grossProfit),
+			"",
+			formatAccounting(report.TotalOpEx-opex),
+			formatAccounting(report.EBITDA-ebitda))
+	}
+	bp.keyFigures(pdfRowGrandTotal, "Consolidated", report)
+}
+
+// keyFigures writes a row of a report's revenue, gross profit and margin, OpEx and EBITDA
+func (bp *boardPack) keyFigures(style pdfRowStyle, label string, report *PLReport) {
+	bp.row(style, 0, label,
+		formatAccounting(report.Revenue),
+		formatAccounting(report.GrossProfit),
+		formatPercent(report.GrossMargin),
+		formatAccounting(report.TotalOpEx),
+		formatAccounting(report.EBITDA))
+}
+
+// quarterlySummary writes the key figures of every department and their total
+func (bp *boardPack) quarterlySummary(report *QuarterlyReport, names []string) {
+	bp.table("Summary by Department", pdfSummaryWidth, "Revenue", "Gross Profit", "Gross Margin", "EBITDA", "Net Income")
+
+	total := statementMetrics{hasEBITDA: true}
+	for _, name := range names {
+		deptData, ok := report.Departments[name]
+		if !ok {
+			continue
+		}
+		metrics := metricsOf(deptData.Tree)
+		metrics.netIncome = deptData.Total
+		bp.row(pdfRowLine, len(departmentPath(name))-1, name, metrics.columns()...)
+
+		total.revenue += metrics.revenue
+		total.grossProfit += metrics.grossProfit
+		total.ebitda += metrics.ebitda
+		total.netIncome += metrics.netIncome
+		total.hasEBITDA = total.hasEBITDA && metrics.hasEBITDA
+	}
+	bp.row(pdfRowGrandTotal, 0, "Total", total.columns()...)
+}
+
+// headcountSplit writes the headcount vs non-headcount split of each department
+func (bp *boardPack) headcountSplit(hc *HCAnalysis) {
+	bp.continueTable("Headcount vs Non-Headcount", pdfAmountWidth, "Headcount", "Non-Headcount", "Total")
+	for _, dept := range hc.Departments {
+		bp.row(pdfRowLine, 0, dept.Department,
+			formatAccounting(dept.Headcount), formatAccounting(dept.NonHeadcount), formatAccounting(dept.Total))
+	}
+	bp.row(pdfRowGrandTotal, 0, "Total",
+		formatAccounting(hc.Headcount), formatAccounting(hc.NonHeadcount), formatAccounting(hc.Total))
+}
+
+// departmentStatement writes a department's statement from its line tree,
+// leaving out lines that are zero throughout
+func (bp *boardPack) departmentStatement(deptData *DepartmentData) {
+	bp.table(deptData.Department, pdfAmountWidth, "Amount")
+
+	var walk func(nodes []*LineItemNode, depth int)
+	walk = func(nodes []*LineItemNode, depth int) {
+		for _, node := range nodes {
+			if isZeroLine(node) {
+				continue
+			}
+			if isSummaryLine(node.Label) {
+				bp.row(pdfRowSubtotal, depth, node.Label, formatAccounting(node.Amount))
+				continue
+			}
+			if len(node.Children) == 0 {
+				bp.row(pdfRowLine, depth, node.Label, formatAccounting(node.Amount))
+				continue
+			}
+
+			amount := ""
+			if node.Amount != 0 {
+				amount = formatAccounting(node.Amount)
+			}
+			bp.row(pdfRowHeading, depth, node.Label, amount)
+			walk(node.Children, depth+1)
+			// The statement's own total is shown when it has one
+			total := node.Total
+			if node.ReportedTotal != nil {
+				total = *node.ReportedTotal
+			}
+			bp.row(pdfRowTotal, depth, "Total - "+node.Label, formatAccounting(total))
+		}
+	}
+	walk(deptData.Tree, 0)
+
+	if _, ok := findNetIncome(deptData.LineItems); !ok {
+		bp.row(pdfRowGrandTotal, 0, "Net Income", formatAccounting(deptData.Total))
+	}
+}
+
+// isZeroLine reports whether a line and everything under it is zero
+func isZeroLine(node *LineItemNode) bool {
+	if node.Amount != 0 {
+		return false
+	}
+	for _, child := range node.Children {
+		if !isZeroLine(child) {
+			return false
+		}
+	}
+	return true
+}
+
+// statementMetrics are the headline figures of a department's statement
+type statementMetrics struct {
+	revenue     float64
+	grossProfit float64
+	ebitda      float64
+	hasEBITDA   bool
+	netIncome   float64
+}
+
+// metricsOf reads revenue, gross profit and EBITDA from a department's line
+// tree. Revenue is the income section and gross profit the "Gross Profit" line,
+// or revenue less the cost of sales section. EBITDA adds depreciation and
+// amortization back to the "Net Ordinary Income" (operating income) line, and
+// is only known when the statement has that line.
+func metricsOf(tree []*LineItemNode) statementMetrics {
+	var m statementMetrics
+	var cogs float64
+	hasRevenue, hasCOGS, hasGrossProfit := false, false, false
+	var depreciation float64
+
+	var walk func(nodes []*LineItemNode, section int)
+	walk = func(nodes []*LineItemNode, section int) {
+		for _, node := range nodes {
+			lower := strings.ToLower(node.Label)
+			switch {
+			case strings.HasPrefix(lower, "gross profit"):
+				if !hasGrossProfit {
+					m.grossProfit, hasGrossProfit = node.Amount, true
+				}
+				continue
+			case strings.HasPrefix(lower, "net ordinary income") || strings.HasPrefix(lower, "net operating income"):
+				if !m.hasEBITDA {
+					m.ebitda, m.hasEBITDA = node.Amount, true
+				}
+				continue
+			case isSummaryLine(node.Label):
+				continue
+			}
+
+			nodeSection := sectionOf(node.Label, section)
+			if len(node.Children) > 0 {
+				if nodeSection == sectionIncome && section != sectionIncome && !hasRevenue && !strings.Contains(lower, "other") {
+					m.revenue, hasRevenue = node.Total, true
+				}
+				if strings.Contains(lower, "cost of") && !hasCOGS {
+					cogs, hasCOGS = node.Total, true
+				}
+				walk(node.Children, nodeSection)
+				continue
+			}
+			if nodeSection == sectionExpense &&
+				(strings.Contains(lower, "depreciation") || strings.Contains(lower, "amortization")) {
+				depreciation += node.Amount
+			}
+		}
+	}
+	walk(tree, sectionNone)
+
+	if !hasGrossProfit {
+		m.grossProfit = m.revenue - cogs
+	}
+	if m.hasEBITDA {
+		m.ebitda += depreciation
+	}
+	return m
+}
+
+// columns formats the metrics for the summary table
+func (m statementMetrics) columns() []string {
+	margin := "-"
+	if m.revenue != 0 {
+		margin = formatPercent(m.grossProfit / m.revenue * 100)
+	}
+	ebitda := "n/a"
+	if m.hasEBITDA {
+		ebitda = formatAccounting(m.ebitda)
+	}
+	return []string{
+		formatAccounting(m.revenue),
+		formatAccounting(m.grossProfit),
+		margin,
+		ebitda,
+		formatAccounting(m.netIncome),
+	}
+}
+
+// formatAccounting formats an amount with thousands separators, negatives in
+// parentheses and zero as a dash
+func formatAccounting(amount float64) string {
+	cents := math.Round(amount * 100)
+	if cents == 0 {
+		return "-"
+	}
+
+	digits := strconv.FormatFloat(math.Abs(cents)/100, 'f', 2, 64)
+	whole, fraction := digits[:len(digits)-3], digits[len(digits)-3:]
+	var b strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(digit)
+	}
+	b.WriteString(fraction)
+
+	if cents < 0 {
+		return "(" + b.String() + ")"
+	}
+	return b.String()
+}
+
+// formatPercent formats a percentage such as GrossMargin
+func formatPercent(percent float64) string {
+	return strconv.FormatFloat(percent, 'f', 1, 64) + "%"
+}
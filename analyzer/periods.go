@@ -0,0 +1,159 @@
+package analyzer
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Period granularities accepted by the "period" form field
+const (
+	periodMonth   = "month"
+	periodQuarter = "quarter"
+	periodYear    = "year"
+)
+
+// undatedPeriod labels transactions whose date could not be read
+const undatedPeriod = "Undated"
+
+// PeriodReport is the P&L for a single month, quarter or fiscal year
+type PeriodReport struct {
+	Period string    `json:"period"`
+	Start  string    `json:"start,omitempty"`
+	End    string    `json:"end,omitempty"`
+	Report *PLReport `json:"report"`
+}
+
+// periodOptions controls how transactions are bucketed into periods
+type periodOptions struct {
+	granularity     string
+	fiscalYearStart time.Month
+}
+
+// periodOptionsFromForm reads the "period" and "fiscalYearStart" form fields
+func periodOptionsFromForm(form url.Values) (periodOptions, error) {
+	opts := periodOptions{
+		granularity:     strings.ToLower(strings.TrimSpace(form.Get("period"))),
+		fiscalYearStart: time.January,
+	}
+
+	switch opts.granularity {
+	case "", periodMonth, periodQuarter, periodYear:
+	default:
+		return opts, fmt.Errorf("unknown period %q (expected month, quarter or year)", opts.granularity)
+	}
+
+	if value := strings.TrimSpace(form.Get("fiscalYearStart")); value != "" {
+		month, err := strconv.Atoi(value)
+		if err != nil || month < 1 || month > 12 {
+			return opts, fmt.Errorf("fiscalYearStart must be a month number between 1 and 12")
+		}
+		opts.fiscalYearStart = time.Month(month)
+	}
+
+	return opts, nil
+}
+
+// bucket returns the label and date range of the period containing t
+func (opts periodOptions) bucket(t time.Time) (string, time.Time, time.Time) {
+	// Months elapsed since the start of the fiscal year, and the fiscal year itself,
+	// named after the calendar year in which it ends
+	offset := (int(t.Month()) - int(opts.fiscalYearStart) + 12) % 12
+	fiscalStart := time.Date(t.Year(), t.Month()-time.Month(offset), 1, 0, 0, 0, 0, time.UTC)
+	fiscalYear := fiscalStart.AddDate(0, 11, 0).Year()
+
+	switch opts.granularity {
+	case periodQuarter:
+		quarter := offset / 3
+		start := fiscalStart.AddDate(0, quarter*3, 0)
+		return fmt.Sprintf("FY%d Q%d", fiscalYear, quarter+1), start, start.AddDate(0, 3, -1)
+	case periodYear:
+		return fmt.Sprintf("FY%d", fiscalYear), fiscalStart, fiscalStart.AddDate(1, 0, -1)
+	default:
+		start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start.Format("2006-01"), start, start.AddDate(0, 1, -1)
+	}
+}
+
+// periodBuilder aggregates transactions into one PLReport per period as they arrive
+type periodBuilder struct {
+	opts       reportOptions
+	periodOpts periodOptions
+	periods    map[string]*periodBucket
+	undated    *plBuilder
+}
+
+// periodBucket is the in-progress report for a single period
+type periodBucket struct {
+	period  *PeriodReport
+	start   time.Time
+	builder *plBuilder
+}
+
+// newPeriodBuilder creates an empty set of period reports
+func newPeriodBuilder(opts reportOptions, periodOpts periodOptions) *periodBuilder {
+	// Only the total report lists unclassified transactions
+	opts.unclassifiedLimit = 0
+	return &periodBuilder{
+		opts:       opts,
+		periodOpts: periodOpts,
+		periods:    make(map[string]*periodBucket),
+	}
+}
+
+// add routes the transaction to the report of the period containing its date.
+// Transactions without a readable date go to a trailing "Undated" period so
+// the periods always add up to the total report.
+func (pb *periodBuilder) add(trans *Transaction, c Classification) {
+	if trans.PostingDate.IsZero() {
+		if pb.undated == nil {
+			pb.undated = newPLBuilder(pb.opts)
+		}
+		pb.undated.add(trans, c)
+		return
+	}
+
+	label, start, end := pb.periodOpts.bucket(trans.PostingDate)
+	b, ok := pb.periods[label]
+	if !ok {
+		b = &periodBucket{
+			period: &PeriodReport{
+				Period: label,
+				Start:  start.Format("2006-01-02"),
+				End:    end.Format("2006-01-02"),
+			},
+			start:   start,
+			builder: newPLBuilder(pb.opts),
+		}
+		pb.periods[label] = b
+	}
+	b.builder.add(trans, c)
+}
+
+// finish completes every period report, ordered chronologically
+func (pb *periodBuilder) finish() []*PeriodReport {
+	ordered := make([]*periodBucket, 0, len(pb.periods))
+	for _, b := range pb.periods {
+		ordered = append(ordered, b)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		return ordered[i].start.Before(ordered[j].start)
+	})
+
+	periods := make([]*PeriodReport, 0, len(ordered)+1)
+	for _, b := range ordered {
+		b.period.Report = b.builder.finish()
+		periods = append(periods, b.period)
+	}
+	if pb.undated != nil {
+		periods = append(periods, &PeriodReport{
+			Period: undatedPeriod,
+			Report: pb.undated.finish(),
+		})
+	}
+
+	return periods
+}
@@ -0,0 +1,580 @@
+package analyzer
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// DepartmentData represents financial data for a department
+type DepartmentData struct {
+	Department string             `json:"department"`
+	Months     []MonthData        `json:"months"`
+	LineItems  []LineItem         `json:"lineItems"`
+	Tree       []*LineItemNode    `json:"tree"`
+	Total      float64            `json:"total"`
+}
+
+// MonthData represents data for a specific month
+type MonthData struct {
+	Month     string     `json:"month"`
+	Amount    float64    `json:"amount"`
+	LineItems []LineItem `json:"lineItems"`
+}
+
+// LineItem is a single line of the statement. Line items keep the statement's
+// row order, and lines that share a label are kept apart.
+type LineItem struct {
+	Row         int     `json:"row"`
+	Label       string  `json:"label"`
+	AccountCode string  `json:"accountCode,omitempty"`
+	Amount      float64 `json:"amount"`
+}
+
+// departmentColumns is the span of columns a department occupies in the statement
+type departmentColumns struct {
+	name     string
+	startCol int
+	endCol   int
+	totalCol int
+	// totalReason says how totalCol was chosen, for the parse trace
+	totalReason string
+	monthCols   []monthColumn
+}
+
+// monthColumn is a department column holding a single month's amounts
+type monthColumn struct {
+	month string
+	col   int
+}
+
+// QuarterlyReport represents the complete quarterly income statement
+type QuarterlyReport struct {
+	CompanyName  string                     `json:"companyName"`
+	Sheet        string                     `json:"sheet,omitempty"`
+	Period       string                     `json:"period"`
+	Departments  map[string]*DepartmentData `json:"departments"`
+	RevenueTotal float64                    `json:"revenueTotal"`
+	Summary      map[string]float64         `json:"summary"`
+	// DepartmentTree nests "Parent : Child" departments with rolled-up totals
+	DepartmentTree []*DepartmentNode `json:"departmentTree"`
+	// HC is the headcount split, returned with mode=hc
+	HC *HCAnalysis `json:"hc,omitempty"`
+	// Trace explains how the statement was read, returned with trace=1
+	Trace *ParseTrace `json:"trace,omitempty"`
+}
+
+// QuarterlyOptions holds the per-request settings for parsing a quarterly statement
+type QuarterlyOptions struct {
+	// Sheet is a worksheet name or 1-based index; empty means auto-detect
+	Sheet string
+	// Layout overrides the detected position of the header and data rows
+	Layout      QuarterlyLayout
+	Departments *DepartmentConfig
+	// Trace collects a ParseTrace into the report
+	Trace bool
+}
+
+// ParseQuarterlyIncomeStatement reads the Excel file and extracts department hierarchy
+func ParseQuarterlyIncomeStatement(r io.Reader, opts QuarterlyOptions) (*QuarterlyReport, error) {
+	f, err := openWorkbook(r)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Pick the sheet holding the statement, skipping cover sheets
+	sheets, err := selectSheets(f, sheetOptions{sheet: opts.Sheet}, func(rows [][]string) bool {
+		return findDepartmentRow(rows, opts.Departments, newStatementLayout(opts.Layout).firstValueCol()) >= 0
+	})
+	if err != nil {
+		return nil, err
+	}
+	sheetName := sheets[0].name
+	rows := sheets[0].rows
+
+	// Locate the department headers, sub-headers and data by content
+	layout, err := detectStatementLayout(rows, opts.Layout, opts.Departments)
+	if err != nil {
+		return nil, err
+	}
+
+	// Extract company name and period
+	report := &QuarterlyReport{
+		Sheet:       sheetName,
+		Departments: make(map[string]*DepartmentData),
+		Summary:     make(map[string]float64),
+	}
+	report.CompanyName, report.Period = findStatementTitle(rows, layout.departmentRow)
+
+	// The trace stays nil, and records nothing, unless it was requested
+	if opts.Trace {
+		report.Trace = &ParseTrace{
+			Sheet:       sheetName,
+			TotalRows:   len(rows),
+			Layout:      layout.describe(),
+			Departments: []TraceDepartment{},
+			SkippedRows: []SkippedRow{},
+			Samples:     []TraceSample{},
+		}
+	}
+	trace := report.Trace
+
+	// Get merged cells to find department column ranges
+	mergeCells, _ := f.GetMergeCells(sheetName)
+	
+	// Find department columns
+	var departments []departmentColumns
+
+	// Try to use merged cells first
+	foundDepts := false
+	for _, merge := range mergeCells {
+		startCol, startRow, _ := excelize.CellNameToCoordinates(merge.GetStartAxis())
+		endCol, endRow, _ := excelize.CellNameToCoordinates(merge.GetEndAxis())
+		
+		// Check if this merge is on the department header row
+		if startRow == layout.departmentRow+1 && endRow == startRow {
+			value := strings.TrimSpace(merge.GetCellValue())
+			if value != "" && opts.Departments.acceptsHeader(value) {
+				foundDepts = true
+				// The Total column is the rightmost column (convert to 0-indexed)
+				totalCol := endCol - 1
+				
+				departments = append(departments, departmentColumns{
+					name:        value,
+					startCol:    startCol - 1,
+					endCol:      endCol - 1,
+					totalCol:    totalCol,
+					totalReason: "last column of the merged header",
+				})
+			}
+		}
+	}
+	
+	// Fallback: scan the department header row if merged cells didn't work
+	if !foundDepts {
+		headerRow := rows[layout.departmentRow]
+		// Trailing empty cells are trimmed from the header row, so the last
+		// department runs to the width of the widest sub-header row instead
+		rowWidth := len(headerRow)
+		for _, subIdx := range layout.subHeaderRows {
+			if len(rows[subIdx]) > rowWidth {
+				rowWidth = len(rows[subIdx])
+			}
+		}
+		for colIdx, cell := range headerRow {
+		cell = strings.TrimSpace(cell)
+		if cell == "" || colIdx < layout.firstValueCol() {
+			continue
+		}
+
+		// Check if this is a main department header
+		if opts.Departments.acceptsHeader(cell) {
+			// Find the "Total" column for this department
+			totalCol := -1
+			totalReason := ""
+			
+			// First, find the end of this department: the next header of any
+			// kind, recognized as a department or not
+			deptEndCol := rowWidth - 1
+			for j := colIdx + 1; j < len(headerRow); j++ {
+				if strings.TrimSpace(headerRow[j]) != "" {
+					deptEndCol = j - 1
+					break
+				}
+			}
+			
+			// Strategy 1: Look in the first sub-header row for "Total" followed by "Amount"
+			// We want the RIGHTMOST "Amount" in this department's range
+			var subHeaders [][]string
+			for _, subIdx := range layout.subHeaderRows {
+				subHeaders = append(subHeaders, rows[subIdx])
+			}
+			if len(subHeaders) > 0 {
+				lastAmountCol := -1
+				for j := colIdx; j <= deptEndCol && j < len(subHeaders[0]); j++ {
+					subHeader := strings.ToLower(strings.TrimSpace(subHeaders[0][j]))
+					// Look for "Amount" columns
+					if subHeader == "amount" {
+						lastAmountCol = j
+						// Don't break - keep looking for the rightmost one
+					}
+				}
+				if lastAmountCol != -1 {
+					totalCol = lastAmountCol
+					totalReason = "last \"Amount\" sub-header"
+				}
+			}
+			
+			// Strategy 2: Look for "Total" in the first sub-header row if no Amount found
+			if totalCol == -1 && len(subHeaders) > 0 {
+				for j := deptEndCol; j >= colIdx && j < len(subHeaders[0]); j-- {
+					subHeader := strings.ToLower(strings.TrimSpace(subHeaders[0][j]))
+					if subHeader == "total" || strings.HasPrefix(subHeader, "total") {
+						totalCol = j
+						totalReason = "\"Total\" sub-header"
+						break
+					}
+				}
+			}
+			
+			// Strategy 3: Look in the remaining sub-header rows for "Total" or "Amount" (some formats have it there)
+			for i := 1; i < len(subHeaders) && totalCol == -1; i++ {
+				subHeaderRow := subHeaders[i]
+				for j := deptEndCol; j >= colIdx && j < len(subHeaderRow); j-- {
+					subHeader := strings.ToLower(strings.TrimSpace(subHeaderRow[j]))
+					if subHeader == "total" || 
+					   strings.HasPrefix(subHeader, "total") ||
+					   subHeader == "amount" {
+						totalCol = j
+						totalReason = fmt.Sprintf("\"Total\" or \"Amount\" in sub-header row %d", layout.subHeaderRows[i]+1)
+						break
+					}
+				}
+			}
+			
+			// Strategy 4: Use the last non-empty column in the department range
+			if totalCol == -1 {
+				// Find the rightmost column with data in this department
+				for j := deptEndCol; j >= colIdx; j-- {
+					hasData := false
+					// Check if this column has numeric data in the first few data rows
+					for rowIdx := layout.dataRow; rowIdx < layout.dataRow+6 && rowIdx < len(rows); rowIdx++ {
+						if j < len(rows[rowIdx]) {
+							val := strings.TrimSpace(rows[rowIdx][j])
+							if val != "" && val != "-" {
+								hasData = true
+								break
+							}
+						}
+					}
+					if hasData {
+						totalCol = j
+						totalReason = "last column with data"
+						break
+					}
+				}
+			}
+			
+			// Fallback: use department start column
+			if totalCol == -1 {
+				totalCol = colIdx
+				totalReason = "department header column"
+			}
+
+			departments = append(departments, departmentColumns{
+				name:        cell,
+				startCol:    colIdx,
+				endCol:      deptEndCol,
+				totalCol:    totalCol,
+				totalReason: totalReason,
+			})
+			}
+		}
+	}
+	
+	// Keep departments in sheet order; merged cells are not listed in column order
+	sort.SliceStable(departments, func(i, j int) bool {
+		return departments[i].startCol < departments[j].startCol
+	})
+
+	// Find the per-month columns within each department
+	for i := range departments {
+		departments[i].monthCols = findMonthColumns(rows, layout, departments[i])
+	}
+
+	// Headers that spell the same department differently are merged into one
+	groups := groupDepartments(departments, opts.Departments)
+	for _, group := range groups {
+		trace.addDepartment(group, departments)
+	}
+
+	// Initialize department data structures
+	for _, group := range groups {
+		deptData := &DepartmentData{
+			Department: group.name,
+			Months:     []MonthData{},
+			LineItems:  []LineItem{},
+		}
+		for _, month := range group.months {
+			deptData.Months = append(deptData.Months, MonthData{
+				Month:     month,
+				LineItems: []LineItem{},
+			})
+		}
+		report.Departments[group.name] = deptData
+	}
+
+	// Parse data rows (starting from the detected first data row)
+	rowsProcessed := 0
+	var lines []statementLine
+	
+	for rowIdx := layout.dataRow; rowIdx < len(rows); rowIdx++ {
+		row := rows[rowIdx]
+		if isBlankRecord(row) {
+			trace.skip(rowIdx, "", "blank row")
+			continue
+		}
+
+		// Get the account name/line item (usually in column A or B)
+		lineItem := layout.label(row)
+
+		// Skip empty line items or headers
+		if lineItem == "" {
+			trace.skip(rowIdx, "", "no line item label")
+			continue
+		}
+		if strings.Contains(lineItem, "Financial") {
+			trace.skip(rowIdx, lineItem, "report heading")
+			continue
+		}
+		
+		rowsProcessed++
+		labelCol, rawLabel := layout.labelCell(row)
+		lines = append(lines, statementLine{
+			rowIdx: rowIdx,
+			label:  lineItem,
+			indent: lineIndent(f, sheetName, rowIdx, labelCol, rawLabel),
+		})
+
+		// Extract amounts for each department from its Total column(s)
+		accountCode, _ := splitAccount(lineItem)
+		for _, group := range groups {
+			deptData := report.Departments[group.name]
+			item := LineItem{Row: rowIdx + 1, Label: lineItem, AccountCode: accountCode}
+			item.Amount = columnsAmounts(rows, group.totalCols)(rowIdx)
+			if item.Amount != 0 {
+				trace.sample(rowIdx, group, lineItem, item.Amount)
+			}
+			deptData.LineItems = append(deptData.LineItems, item)
+
+			// Per-month amounts for the line item
+			for i := range deptData.Months {
+				month := &deptData.Months[i]
+				monthItem := item
+				monthItem.Amount = columnsAmounts(rows, group.monthCols[month.Month])(rowIdx)
+				month.LineItems = append(month.LineItems, monthItem)
+			}
+		}
+	}
+	
+	if trace != nil {
+		trace.RowsProcessed = rowsProcessed
+	}
+
+	// Rebuild the account hierarchy once and fill in each department's amounts.
+	// The tree's top-level sum leaves out Total rows, so they are not counted twice.
+	roots := buildLineTree(lines)
+	for _, group := range groups {
+		deptData := report.Departments[group.name]
+		deptData.Tree, deptData.Total = materializeTree(roots, columnsAmounts(rows, group.totalCols))
+		for i := range deptData.Months {
+			month := &deptData.Months[i]
+			_, month.Amount = materializeTree(roots, columnsAmounts(rows, group.monthCols[month.Month]))
+		}
+	}
+
+	// Calculate summary metrics - use Net Income/Loss as the total
+	for deptName, deptData := range report.Departments {
+		netIncomeValue := deptData.Total // Default to the sum of the statement lines
+		if amount, ok := findNetIncome(deptData.LineItems); ok {
+			netIncomeValue = amount
+		}
+
+		// Use Net Income as the department total
+		deptData.Total = netIncomeValue
+		report.Summary[deptName] = netIncomeValue
+		
+		if strings.Contains(strings.ToLower(deptName), "revenue") {
+			report.RevenueTotal += netIncomeValue
+		}
+
+		// Each month's total follows the same rule
+		for i := range deptData.Months {
+			month := &deptData.Months[i]
+			if amount, ok := findNetIncome(month.LineItems); ok {
+				month.Amount = amount
+			}
+		}
+	}
+
+	// Nest sub-departments under their parents, in sheet order
+	var names []string
+	for _, group := range groups {
+		names = append(names, group.name)
+	}
+	report.DepartmentTree = buildDepartmentTree(names, report.Summary)
+
+	return report, nil
+}
+
+// departmentGroup is a department with the columns of every header that names
+// it, such as both "G&A" and "General & Administrative"
+type departmentGroup struct {
+	name      string
+	headers   []string
+	totalCols []int
+	// months lists the month labels in sheet order; monthCols holds their columns
+	months    []string
+	monthCols map[string][]int
+}
+
+// groupDepartments merges departments whose headers share a canonical name,
+// keeping the sheet order of their first column. Names that differ only in
+// case are merged too, under the spelling seen first.
+func groupDepartments(departments []departmentColumns, config *DepartmentConfig) []*departmentGroup {
+	var groups []*departmentGroup
+	byKey := make(map[string]*departmentGroup)
+	for _, dept := range departments {
+		name := config.canonicalName(dept.name)
+		key := strings.ToLower(name)
+		group, ok := byKey[key]
+		if !ok {
+			group = &departmentGroup{name: name, monthCols: make(map[string][]int)}
+			byKey[key] = group
+			groups = append(groups, group)
+		}
+
+		group.headers = append(group.headers, dept.name)
+		group.totalCols = append(group.totalCols, dept.totalCol)
+		for _, mc := range dept.monthCols {
+			if _, ok := group.monthCols[mc.month]; !ok {
+				group.months = append(group.months, mc.month)
+			}
+			group.monthCols[mc.month] = append(group.monthCols[mc.month], mc.col)
+		}
+	}
+	return groups
+}
+
+// columnsAmounts reads the amounts of a set of columns, summed by row
+func columnsAmounts(rows [][]string, cols []int) func(rowIdx int) float64 {
+	return func(rowIdx int) float64 {
+		var amount float64
+		for _, col := range cols {
+			if col >= 0 && col < len(rows[rowIdx]) {
+				amount += parseAmountQuarterly(rows[rowIdx][col])
+			}
+		}
+		return amount
+	}
+}
+
+// findNetIncome returns the first "Net Income" or "Net Loss" line item, if present
+func findNetIncome(lineItems []LineItem) (float64, bool) {
+	for _, item := range lineItems {
+		lineItemLower := strings.ToLower(strings.TrimSpace(item.Label))
+		
+		// Must start with "net" and contain "income" or "loss"
+		// Exclude "Total - Other Income" and similar
+		if strings.HasPrefix(lineItemLower, "net ") && 
+		   (strings.Contains(lineItemLower, "income") || 
+		    strings.Contains(lineItemLower, "loss")) &&
+		   !strings.Contains(lineItemLower, "other") &&
+		   !strings.Contains(lineItemLower, "ordinary") {
+			return item.Amount, true
+		}
+	}
+	return 0, false
+}
+
+// findMonthColumns returns the columns of a department whose sub-header is a
+// month caption such as "Jan 2025", excluding its Total column
+func findMonthColumns(rows [][]string, layout statementLayout, dept departmentColumns) []monthColumn {
+	var months []monthColumn
+	for col := dept.startCol; col <= dept.endCol; col++ {
+		if col == dept.totalCol {
+			continue
+		}
+		for _, rowIdx := range layout.subHeaderRows {
+			if col >= len(rows[rowIdx]) {
+				continue
+			}
+			if month, ok := parseMonthHeader(rows[rowIdx][col]); ok {
+				months = append(months, monthColumn{month: month.Format("2006-01"), col: col})
+				break
+			}
+		}
+	}
+	return months
+}
+
+// isMainDepartment checks if a header is a main department
+func isMainDepartment(header string) bool {
+	header = strings.ToLower(strings.TrimSpace(header))
+	
+	// Direct matches
+	mainDepts := []string{
+		"general and administrative",
+		"general & administrative",
+		"g&a",
+		"marketing",
+		"research & development",
+		"research and development",
+		"r&d",
+		"revenue",
+		"sales",
+		"cost of revenue",
+		"cogs",
+	}
+
+	for _, dept := range mainDepts {
+		if header == dept {
+			return true
+		}
+	}
+	
+	// Partial matches (more flexible)
+	if strings.Contains(header, "general") && strings.Contains(header, "administrative") {
+		return true
+	}
+	if strings.Contains(header, "g&a") {
+		return true
+	}
+	if header == "marketing" {
+		return true
+	}
+	if strings.Contains(header, "research") && strings.Contains(header, "development") {
+		return true
+	}
+	if header == "r&d" {
+		return true
+	}
+	if header == "revenue" || header == "revenues" {
+		return true
+	}
+
+	return false
+}
+
+// parseAmountQuarterly converts a string to float64, handling currency and formatting
+func parseAmountQuarterly(s string) float64 {
+	s = strings.TrimSpace(s)
+	s = strings.ReplaceAll(s, ",", "")
+	s = strings.ReplaceAll(s, "$", "")
+	
+	// Handle parentheses as negative
+	isNegative := false
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		isNegative = true
+		s = strings.Trim(s, "()")
+	}
+	
+	if s == "" || s == "-" {
+		return 0
+	}
+	
+	val, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	
+	if isNegative {
+		return -val
+	}
+	return val
+}
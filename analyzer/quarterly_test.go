@@ -0,0 +1,101 @@
+package analyzer
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// buildStatement writes a quarterly statement workbook with one row per slice
+func buildStatement(t *testing.T, rows [][]interface{}) *bytes.Reader {
+	t.Helper()
+	f := excelize.NewFile()
+	defer f.Close()
+	for i, row := range rows {
+		cell, _ := excelize.CoordinatesToCellName(1, i+1)
+		if err := f.SetSheetRow("Sheet1", cell, &row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+// departmentSpanStatement has three departments of three months and a Total
+// each. "Customer Success" is not a built-in department and "Ops" is the last
+// header on the department row.
+func departmentSpanStatement(t *testing.T) *bytes.Reader {
+	months := []interface{}{"Jan 2025", "Feb 2025", "Mar 2025", "Total"}
+	subHeaders := append([]interface{}{nil, nil}, months...)
+	subHeaders = append(subHeaders, months...)
+	subHeaders = append(subHeaders, months...)
+	return buildStatement(t, [][]interface{}{
+		{"Acme Corp"},
+		{"Income Statement Q1 2025"},
+		{nil, nil, "R&D : Platform", nil, nil, nil, "Customer Success", nil, nil, nil, "Ops"},
+		subHeaders,
+		{"4000 - Revenue", nil, 1, 2, 3, 6, 100, 200, 300, 600, 10, 11, 12, 33},
+	})
+}
+
+func TestParseQuarterlyDepartmentSpans(t *testing.T) {
+	tests := []struct {
+		name        string
+		departments *DepartmentConfig
+		// want maps each expected department to its total and monthly amounts
+		want map[string][]float64
+	}{
+		{
+			name:        "unrecognized header ends the previous department",
+			departments: &DepartmentConfig{Departments: []DepartmentRule{{Name: "Ops"}}},
+			want: map[string][]float64{
+				"R&D : Platform": {6, 1, 2, 3},
+				"Ops":            {33, 10, 11, 12},
+			},
+		},
+		{
+			name:        "discoverAll reads every department through the last one",
+			departments: &DepartmentConfig{DiscoverAll: true},
+			want: map[string][]float64{
+				"R&D : Platform":   {6, 1, 2, 3},
+				"Customer Success": {600, 100, 200, 300},
+				"Ops":              {33, 10, 11, 12},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := ParseQuarterlyIncomeStatement(departmentSpanStatement(t), QuarterlyOptions{Departments: tt.departments})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Departments) != len(tt.want) {
+				t.Errorf("got %d departments, want %d", len(report.Departments), len(tt.want))
+			}
+			for name, want := range tt.want {
+				dept, ok := report.Departments[name]
+				if !ok {
+					t.Errorf("department %q missing", name)
+					continue
+				}
+				if dept.Total != want[0] {
+					t.Errorf("%s total = %v, want %v", name, dept.Total, want[0])
+				}
+				if len(dept.Months) != len(want)-1 {
+					t.Errorf("%s has %d months, want %d", name, len(dept.Months), len(want)-1)
+					continue
+				}
+				for i, month := range dept.Months {
+					if month.Amount != want[i+1] {
+						t.Errorf("%s %s = %v, want %v", name, month.Month, month.Amount, want[i+1])
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package analyzer
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// P&L buckets a transaction can be classified into
+const (
+	bucketRevenue = "revenue"
+	bucketCOGS    = "cogs"
+	bucketOpEx    = "opex"
+)
+
+// mappingFileEnv names the environment variable pointing at the server-side default mapping
+const mappingFileEnv = "ACCOUNT_MAPPING_FILE"
+
+// AccountMapping is a chart-of-accounts mapping that decides where transactions land on the P&L
+type AccountMapping struct {
+	Rules    []MappingRule `json:"rules" yaml:"rules"`
+	Fallback *MappingRule  `json:"fallback,omitempty" yaml:"fallback,omitempty"`
+	// Eliminations remove intercompany transactions from the consolidated report
+	Eliminations []EliminationRule `json:"eliminations,omitempty" yaml:"eliminations,omitempty"`
+}
+
+// MappingRule matches transactions and assigns their P&L bucket.
+// Every criterion that is set must match; values within a criterion are alternatives.
+type MappingRule struct {
+	Name          string   `json:"name,omitempty" yaml:"name,omitempty"`
+	Priority      int      `json:"priority,omitempty" yaml:"priority,omitempty"`
+	AccountRanges []string `json:"accountRanges,omitempty" yaml:"accountRanges,omitempty"`
+	Accounts      []string `json:"accounts,omitempty" yaml:"accounts,omitempty"`
+	Departments   []string `json:"departments,omitempty" yaml:"departments,omitempty"`
+	Classes       []string `json:"classes,omitempty" yaml:"classes,omitempty"`
+	Bucket        string   `json:"bucket" yaml:"bucket"`
+	Category      string   `json:"category,omitempty" yaml:"category,omitempty"`
+	Subcategory   string   `json:"subcategory,omitempty" yaml:"subcategory,omitempty"`
+	Headcount     *bool    `json:"headcount,omitempty" yaml:"headcount,omitempty"`
+
+	ranges []accountRange
+}
+
+// accountRange is an inclusive range of numeric GL account codes
+type accountRange struct {
+	from int
+	to   int
+}
+
+// Classification is the outcome of classifying a single transaction
+type Classification struct {
+	Bucket      string
+	Category    string
+	Subcategory string
+	Headcount   bool
+}
+
+// ParseAccountMapping decodes a YAML or JSON mapping file and validates its rules
+func ParseAccountMapping(data []byte) (*AccountMapping, error) {
+	mapping := &AccountMapping{}
+	// JSON is a subset of YAML, so a single decoder handles both formats
+	if err := yaml.Unmarshal(data, mapping); err != nil {
+		return nil, fmt.Errorf("invalid mapping file: %w", err)
+	}
+
+	for i := range mapping.Rules {
+		if err := mapping.Rules[i].compile(); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+	}
+	if mapping.Fallback != nil {
+		if err := mapping.Fallback.compile(); err != nil {
+			return nil, fmt.Errorf("fallback: %w", err)
+		}
+	}
+	for i := range mapping.Eliminations {
+		if err := mapping.Eliminations[i].compile(i); err != nil {
+			return nil, fmt.Errorf("elimination %d: %w", i+1, err)
+		}
+	}
+
+	// Highest priority first; rules with equal priority keep their file order
+	sort.SliceStable(mapping.Rules, func(i, j int) bool {
+		return mapping.Rules[i].Priority > mapping.Rules[j].Priority
+	})
+
+	return mapping, nil
+}
+
+// LoadDefaultAccountMapping reads the server-side mapping file, if one is configured
+func LoadDefaultAccountMapping() (*AccountMapping, error) {
+	path := os.Getenv(mappingFileEnv)
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read default mapping: %w", err)
+	}
+	return ParseAccountMapping(data)
+}
+
+// compile validates the rule and pre-parses its account ranges
+func (rule *MappingRule) compile() error {
+	rule.Bucket = strings.ToLower(strings.TrimSpace(rule.Bucket))
+	switch rule.Bucket {
+	case bucketRevenue, bucketCOGS:
+	case bucketOpEx:
+		if strings.TrimSpace(rule.Category) == "" {
+			return fmt.Errorf("opex rules require a category")
+		}
+	default:
+		return fmt.Errorf("unknown bucket %q (expected revenue, cogs or opex)", rule.Bucket)
+	}
+
+	rule.ranges = nil
+	for _, spec := range rule.AccountRanges {
+		rng, err := parseAccountRange(spec)
+		if err != nil {
+			return err
+		}
+		rule.ranges = append(rule.ranges, rng)
+	}
+	return nil
+}
+
+// parseAccountRange parses "4000-4999" or a single account code such as "4000"
+func parseAccountRange(spec string) (accountRange, error) {
+	parts := strings.SplitN(spec, "-", 2)
+	from, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return accountRange{}, fmt.Errorf("invalid account range %q", spec)
+	}
+	to := from
+	if len(parts) == 2 {
+		to, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return accountRange{}, fmt.Errorf("invalid account range %q", spec)
+		}
+	}
+	if to < from {
+		return accountRange{}, fmt.Errorf("invalid account range %q: end is before start", spec)
+	}
+	return accountRange{from: from, to: to}, nil
+}
+
+// splitAccount splits a NetSuite account such as "4000 - Revenue" into its code and name
+func splitAccount(account string) (string, string) {
+	account = strings.TrimSpace(account)
+	end := 0
+	for end < len(account) && account[end] >= '0' && account[end] <= '9' {
+		end++
+	}
+	if end == 0 {
+		return "", account
+	}
+
+	name := strings.TrimSpace(account[end:])
+	name = strings.TrimSpace(strings.TrimPrefix(name, "-"))
+	return account[:end], name
+}
+
+// matches reports whether every criterion set on the rule matches the transaction
+func (rule *MappingRule) matches(trans Transaction) bool {
+	if !matchesAccount(rule.ranges, rule.Accounts, trans) {
+		return false
+	}
+	if len(rule.Departments) > 0 && !matchesAny(rule.Departments, trans.Department) {
+		return false
+	}
+	if len(rule.Classes) > 0 && !matchesAny(rule.Classes, trans.Class) {
+		return false
+	}
+	return true
+}
+
+// matchesAccount reports whether the transaction's account is in one of the
+// ranges and is one of the accounts; an empty criterion matches any account
+func matchesAccount(ranges []accountRange, accounts []string, trans Transaction) bool {
+	if len(ranges) > 0 {
+		code, err := strconv.Atoi(trans.AccountNumber)
+		if err != nil {
+			return false
+		}
+		inRange := false
+		for _, rng := range ranges {
+			if code >= rng.from && code <= rng.to {
+				inRange = true
+				break
+			}
+		}
+		if !inRange {
+			return false
+		}
+	}
+
+	return len(accounts) == 0 ||
+		matchesAny(accounts, trans.Account) ||
+		matchesAny(accounts, trans.AccountName) ||
+		matchesAny(accounts, trans.AccountNumber)
+}
+
+// matchesAny does a case-insensitive exact comparison against each candidate
+func matchesAny(candidates []string, value string) bool {
+	value = strings.TrimSpace(value)
+	for _, candidate := range candidates {
+		if strings.EqualFold(strings.TrimSpace(candidate), value) {
+			return true
+		}
+	}
+	return false
+}
+
+// standardAccountRanges is the built-in GL numbering used when no mapping rule applies
+var standardAccountRanges = mustParseAccountMapping(`
+rules:
+  - {name: Revenue, accountRanges: ["4000-4999"], bucket: revenue}
+  - {name: COGS, accountRanges: ["5000-5999"], bucket: cogs}
+  - {name: G&A, accountRanges: ["6000-6999"], bucket: opex, category: G&A}
+  - {name: R&D, accountRanges: ["7000-7999"], bucket: opex, category: R&D}
+  - {name: S&M, accountRanges: ["8000-8999"], bucket: opex, category: S&M}
+`)
+
+// mustParseAccountMapping parses a built-in mapping and panics if it is invalid
+func mustParseAccountMapping(data string) *AccountMapping {
+	mapping, err := ParseAccountMapping([]byte(data))
+	if err != nil {
+		panic(err)
+	}
+	return mapping
+}
+
+// classify decides the P&L bucket for a transaction. The mapping's rules and
+// fallback are tried first, then the standard account number ranges, and
+// finally the built-in keyword classifiers for accounts without a GL code.
+func (m *AccountMapping) classify(trans Transaction) Classification {
+	if m != nil {
+		for i := range m.Rules {
+			if m.Rules[i].matches(trans) {
+				return m.Rules[i].apply(trans)
+			}
+		}
+		if m.Fallback != nil {
+			return m.Fallback.apply(trans)
+		}
+	}
+	for i := range standardAccountRanges.Rules {
+		if standardAccountRanges.Rules[i].matches(trans) {
+			return standardAccountRanges.Rules[i].apply(trans)
+		}
+	}
+	return classifyByKeywords(trans)
+}
+
+// apply builds the classification for a matched rule, filling unset fields from the keyword classifiers
+func (rule *MappingRule) apply(trans Transaction) Classification {
+	accountLower := strings.ToLower(trans.Account)
+	deptLower := strings.ToLower(trans.Department)
+	classLower := strings.ToLower(trans.Class)
+
+	c := Classification{
+		Bucket:      rule.Bucket,
+		Category:    strings.TrimSpace(rule.Category),
+		Subcategory: strings.TrimSpace(rule.Subcategory),
+	}
+
+	if rule.Headcount != nil {
+		c.Headcount = *rule.Headcount
+	} else {
+		c.Headcount = isHeadcountCost(accountLower, strings.ToLower(trans.Memo))
+	}
+
+	if c.Subcategory == "" {
+		switch c.Bucket {
+		case bucketCOGS:
+			c.Subcategory = determineCOGSSubcategory(deptLower, classLower, accountLower)
+		case bucketOpEx:
+			c.Subcategory = determineSubcategory(c.Category, deptLower, classLower, accountLower)
+		}
+	}
+
+	return c
+}
+
+// classifyByKeywords applies the original substring-based classification
+func classifyByKeywords(trans Transaction) Classification {
+	accountLower := strings.ToLower(trans.Account)
+	deptLower := strings.ToLower(trans.Department)
+	classLower := strings.ToLower(trans.Class)
+	memoLower := strings.ToLower(trans.Memo)
+
+	c := Classification{Headcount: isHeadcountCost(accountLower, memoLower)}
+
+	if isRevenue(accountLower) {
+		c.Bucket = bucketRevenue
+	} else if isCOGS(accountLower, deptLower) {
+		c.Bucket = bucketCOGS
+		c.Subcategory = determineCOGSSubcategory(deptLower, classLower, accountLower)
+	} else if category := determineOpExCategory(accountLower, deptLower, classLower); category != "" {
+		c.Bucket = bucketOpEx
+		c.Category = category
+		c.Subcategory = determineSubcategory(category, deptLower, classLower, accountLower)
+	}
+
+	return c
+}
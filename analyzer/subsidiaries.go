@@ -0,0 +1,159 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// unassignedSubsidiary labels transactions without a subsidiary in a file that has them
+const unassignedSubsidiary = "Unassigned"
+
+// SubsidiaryReport is the P&L of a single subsidiary, before intercompany eliminations
+type SubsidiaryReport struct {
+	Subsidiary string    `json:"subsidiary"`
+	Report     *PLReport `json:"report"`
+}
+
+// EliminationRule matches intercompany transactions to remove from the
+// consolidated report. Every criterion that is set must match; values within a
+// criterion are alternatives. Counterparties are matched against the Name
+// column, which holds the customer, vendor or other subsidiary.
+type EliminationRule struct {
+	Name           string   `json:"name,omitempty" yaml:"name,omitempty"`
+	AccountRanges  []string `json:"accountRanges,omitempty" yaml:"accountRanges,omitempty"`
+	Accounts       []string `json:"accounts,omitempty" yaml:"accounts,omitempty"`
+	Counterparties []string `json:"counterparties,omitempty" yaml:"counterparties,omitempty"`
+	Subsidiaries   []string `json:"subsidiaries,omitempty" yaml:"subsidiaries,omitempty"`
+
+	ranges []accountRange
+}
+
+// EliminationTotal sums the transactions an elimination rule removed from the
+// consolidated report, using sign-normalized amounts
+type EliminationTotal struct {
+	Rule     string  `json:"rule"`
+	Count    int     `json:"count"`
+	Revenue  float64 `json:"revenue"`
+	Expenses float64 `json:"expenses"`
+}
+
+// compile validates the rule, names it after its position when unnamed and
+// pre-parses its account ranges
+func (rule *EliminationRule) compile(index int) error {
+	if len(rule.AccountRanges) == 0 && len(rule.Accounts) == 0 && len(rule.Counterparties) == 0 {
+		return fmt.Errorf("eliminations require accountRanges, accounts or counterparties")
+	}
+	if strings.TrimSpace(rule.Name) == "" {
+		rule.Name = fmt.Sprintf("Elimination %d", index+1)
+	}
+
+	rule.ranges = nil
+	for _, spec := range rule.AccountRanges {
+		rng, err := parseAccountRange(spec)
+		if err != nil {
+			return err
+		}
+		rule.ranges = append(rule.ranges, rng)
+	}
+	return nil
+}
+
+// matches reports whether every criterion set on the rule matches the transaction
+func (rule *EliminationRule) matches(trans Transaction) bool {
+	if !matchesAccount(rule.ranges, rule.Accounts, trans) {
+		return false
+	}
+	if len(rule.Counterparties) > 0 && !matchesAny(rule.Counterparties, trans.Name) {
+		return false
+	}
+	if len(rule.Subsidiaries) > 0 && !matchesAny(rule.Subsidiaries, trans.Subsidiary) {
+		return false
+	}
+	return true
+}
+
+// elimination returns the first elimination rule matching the transaction, or nil
+func (m *AccountMapping) elimination(trans Transaction) *EliminationRule {
+	if m == nil {
+		return nil
+	}
+	for i := range m.Eliminations {
+		if m.Eliminations[i].matches(trans) {
+			return &m.Eliminations[i]
+		}
+	}
+	return nil
+}
+
+// subsidiaryBuilder aggregates transactions into one PLReport per subsidiary.
+// Subsidiary reports are the entities' own books, so nothing is eliminated.
+type subsidiaryBuilder struct {
+	opts     reportOptions
+	names    map[string]string
+	builders map[string]*plBuilder
+	named    bool
+}
+
+// newSubsidiaryBuilder creates an empty set of subsidiary reports
+func newSubsidiaryBuilder(opts reportOptions) *subsidiaryBuilder {
+	opts.eliminate = false
+	// Only the total report lists unclassified transactions
+	opts.unclassifiedLimit = 0
+	return &subsidiaryBuilder{
+		opts:     opts,
+		names:    make(map[string]string),
+		builders: make(map[string]*plBuilder),
+	}
+}
+
+// add routes the transaction to its subsidiary's report. Subsidiaries are
+// matched case-insensitively and reported under the first spelling seen;
+// transactions without one go to "Unassigned" so the subsidiaries add up to
+// the consolidated report before eliminations.
+func (sb *subsidiaryBuilder) add(trans *Transaction, c Classification) {
+	name := strings.Join(strings.Fields(trans.Subsidiary), " ")
+	if name == "" {
+		name = unassignedSubsidiary
+	} else {
+		sb.named = true
+	}
+
+	key := strings.ToLower(name)
+	b, ok := sb.builders[key]
+	if !ok {
+		b = newPLBuilder(sb.opts)
+		sb.builders[key] = b
+		sb.names[key] = name
+	}
+	b.add(trans, c)
+}
+
+// finish completes every subsidiary report, ordered by name with unassigned
+// transactions last. Files without a subsidiary column have no subsidiary reports.
+func (sb *subsidiaryBuilder) finish() []*SubsidiaryReport {
+	if !sb.named {
+		return nil
+	}
+
+	unassigned := strings.ToLower(unassignedSubsidiary)
+	keys := make([]string, 0, len(sb.builders))
+	for key := range sb.builders {
+		if key != unassigned {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if _, ok := sb.builders[unassigned]; ok {
+		keys = append(keys, unassigned)
+	}
+
+	subsidiaries := make([]*SubsidiaryReport, 0, len(keys))
+	for _, key := range keys {
+		subsidiaries = append(subsidiaries, &SubsidiaryReport{
+			Subsidiary: sb.names[key],
+			Report:     sb.builders[key].finish(),
+		})
+	}
+	return subsidiaries
+}
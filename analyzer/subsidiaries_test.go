@@ -0,0 +1,116 @@
+package analyzer
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// subsidiaryCSV books intercompany revenue at Acme Inc and the matching
+// management fee at Acme UK Ltd, whose name is spelled two ways
+const subsidiaryCSV = `Date,Type,Document Number,Name,Account,Department,Subsidiary,Amount
+2024-01-15,Invoice,INV-1,Customer A,4000 - Revenue,Sales,Acme Inc,1000
+2024-01-15,Invoice,INV-2,Acme UK Ltd,4950 - Intercompany Revenue,Sales,Acme Inc,200
+2024-01-15,Bill,BILL-1,Acme Inc,6500 - Management Fees,G&A,Acme UK Ltd,200
+2024-01-15,Invoice,INV-3,Customer B,4000 - Revenue,Sales,acme uk ltd,500
+2024-01-15,Bill,BILL-2,Vendor,6100 - Salaries,R&D,,300
+`
+
+// subsidiaryTotals is the expected revenue and OpEx of one subsidiary report
+type subsidiaryTotals struct {
+	name    string
+	revenue float64
+	opex    float64
+}
+
+func TestSubsidiaryConsolidation(t *testing.T) {
+	tests := []struct {
+		name         string
+		csv          string
+		eliminations string
+		revenue      float64
+		opex         float64
+		eliminated   []EliminationTotal
+		subsidiaries []subsidiaryTotals
+	}{
+		{
+			name: "intercompany transactions are eliminated from the consolidated report only",
+			csv:  subsidiaryCSV,
+			eliminations: `eliminations:
+  - name: Intercompany revenue
+    accountRanges: ["4900-4999"]
+  - name: Management fees
+    accounts: ["Management Fees"]
+    counterparties: ["Acme Inc", "Acme UK Ltd"]
+`,
+			revenue: 1500,
+			opex:    300,
+			eliminated: []EliminationTotal{
+				{Rule: "Intercompany revenue", Count: 1, Revenue: 200},
+				{Rule: "Management fees", Count: 1, Expenses: 200},
+			},
+			subsidiaries: []subsidiaryTotals{
+				{name: "Acme Inc", revenue: 1200},
+				{name: "Acme UK Ltd", revenue: 500, opex: 200},
+				{name: "Unassigned", opex: 300},
+			},
+		},
+		{
+			name: "rules limited to a subsidiary leave the others alone",
+			csv:  subsidiaryCSV,
+			eliminations: `eliminations:
+  - accountRanges: ["4900-4999"]
+    subsidiaries: ["Acme UK Ltd"]
+`,
+			revenue: 1700,
+			opex:    500,
+			subsidiaries: []subsidiaryTotals{
+				{name: "Acme Inc", revenue: 1200},
+				{name: "Acme UK Ltd", revenue: 500, opex: 200},
+				{name: "Unassigned", opex: 300},
+			},
+		},
+		{
+			name: "files without subsidiaries have no subsidiary reports",
+			csv: `Date,Type,Document Number,Name,Account,Department,Amount
+2024-01-15,Invoice,INV-1,Customer A,4000 - Revenue,Sales,1000
+`,
+			revenue: 1000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mapping *AccountMapping
+			if tt.eliminations != "" {
+				var err error
+				if mapping, err = ParseAccountMapping([]byte(tt.eliminations)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			report, err := AnalyzeUpload(strings.NewReader(tt.csv), "export.csv", url.Values{}, mapping, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if report.Revenue != tt.revenue || report.TotalOpEx != tt.opex {
+				t.Errorf("consolidated revenue %v, OpEx %v; want %v, %v", report.Revenue, report.TotalOpEx, tt.revenue, tt.opex)
+			}
+			if !reflect.DeepEqual(report.Eliminations, tt.eliminated) {
+				t.Errorf("eliminations = %+v, want %+v", report.Eliminations, tt.eliminated)
+			}
+			if got := report.Reconciliation.EliminatedCount; got != len(tt.eliminated) {
+				t.Errorf("eliminated %d transactions, want %d", got, len(tt.eliminated))
+			}
+
+			var got []subsidiaryTotals
+			for _, sub := range report.Subsidiaries {
+				got = append(got, subsidiaryTotals{name: sub.Subsidiary, revenue: sub.Report.Revenue, opex: sub.Report.TotalOpEx})
+			}
+			if !reflect.DeepEqual(got, tt.subsidiaries) {
+				t.Errorf("subsidiaries = %+v, want %+v", got, tt.subsidiaries)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package analyzer
+
+import "github.com/xuri/excelize/v2"
+
+// traceSampleLimit caps the number of sample values kept in a parse trace
+const traceSampleLimit = 5
+
+// ParseTrace explains how a quarterly statement was read: where the headers and
+// data were found, which columns each department was read from, which rows were
+// skipped and a few of the values read. It is returned only with trace=1.
+type ParseTrace struct {
+	Sheet         string            `json:"sheet"`
+	TotalRows     int               `json:"totalRows"`
+	Layout        QuarterlyLayout   `json:"layout"`
+	Departments   []TraceDepartment `json:"departments"`
+	RowsProcessed int               `json:"rowsProcessed"`
+	ValuesFound   int               `json:"valuesFound"`
+	SkippedRows   []SkippedRow      `json:"skippedRows"`
+	Samples       []TraceSample     `json:"samples"`
+}
+
+// TraceDepartment records the columns a department was read from. Headers lists
+// every header merged into the department; each has its own total column.
+type TraceDepartment struct {
+	Department   string              `json:"department"`
+	Headers      []string            `json:"headers"`
+	TotalColumns []TraceColumn       `json:"totalColumns"`
+	MonthColumns map[string][]string `json:"monthColumns,omitempty"`
+}
+
+// TraceColumn is a total column and the rule that chose it
+type TraceColumn struct {
+	Header string `json:"header"`
+	Column string `json:"column"`
+	// Span is the range of columns under the department header, as in "C:F"
+	Span   string `json:"span"`
+	Reason string `json:"reason"`
+}
+
+// SkippedRow is a row below the headers that was not read as a line item
+type SkippedRow struct {
+	Row    int    `json:"row"`
+	Label  string `json:"label,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// TraceSample is a value read from the statement
+type TraceSample struct {
+	Row        int      `json:"row"`
+	Department string   `json:"department"`
+	Label      string   `json:"label"`
+	Columns    []string `json:"columns"`
+	Amount     float64  `json:"amount"`
+}
+
+// addDepartment records the columns chosen for a department
+func (t *ParseTrace) addDepartment(group *departmentGroup, departments []departmentColumns) {
+	if t == nil {
+		return
+	}
+
+	dept := TraceDepartment{Department: group.name, Headers: group.headers}
+	for _, cols := range departments {
+		if !containsString(group.headers, cols.name) {
+			continue
+		}
+		dept.TotalColumns = append(dept.TotalColumns, TraceColumn{
+			Header: cols.name,
+			Column: columnName(cols.totalCol),
+			Span:   columnName(cols.startCol) + ":" + columnName(cols.endCol),
+			Reason: cols.totalReason,
+		})
+	}
+	if len(group.months) > 0 {
+		dept.MonthColumns = make(map[string][]string)
+		for _, month := range group.months {
+			dept.MonthColumns[month] = columnNames(group.monthCols[month])
+		}
+	}
+	t.Departments = append(t.Departments, dept)
+}
+
+// skip records a row that was not read as a line item
+func (t *ParseTrace) skip(rowIdx int, label, reason string) {
+	if t == nil {
+		return
+	}
+	t.SkippedRows = append(t.SkippedRows, SkippedRow{Row: rowIdx + 1, Label: label, Reason: reason})
+}
+
+// sample records a non-zero value read for a department, up to traceSampleLimit
+func (t *ParseTrace) sample(rowIdx int, group *departmentGroup, label string, amount float64) {
+	if t == nil {
+		return
+	}
+	t.ValuesFound++
+	if len(t.Samples) < traceSampleLimit {
+		t.Samples = append(t.Samples, TraceSample{
+			Row:        rowIdx + 1,
+			Department: group.name,
+			Label:      label,
+			Columns:    columnNames(group.totalCols),
+			Amount:     amount,
+		})
+	}
+}
+
+// columnName converts a 0-based column index to its letter, as in "C"
+func columnName(col int) string {
+	name, err := excelize.ColumnNumberToName(col + 1)
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+// columnNames converts 0-based column indices to their letters
+func columnNames(cols []int) []string {
+	names := make([]string, 0, len(cols))
+	for _, col := range cols {
+		names = append(names, columnName(col))
+	}
+	return names
+}
+
+// containsString reports whether a value is in the list
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// maxUploadEnv names the environment variable holding the upload limit in megabytes
+const maxUploadEnv = "MAX_UPLOAD_MB"
+
+// defaultMaxUploadMB is the upload limit used when MAX_UPLOAD_MB is not set
+const defaultMaxUploadMB = 512
+
+// Limits for the small parts that accompany an upload
+const (
+	MaxFieldBytes   = 64 << 10 // 64 KB
+	MaxMappingBytes = 1 << 20  // 1 MB
+)
+
+// MaxUploadBytes returns the configured request size limit
+func MaxUploadBytes() int64 {
+	mb, err := strconv.ParseInt(os.Getenv(maxUploadEnv), 10, 64)
+	if err != nil || mb <= 0 {
+		mb = defaultMaxUploadMB
+	}
+	return mb << 20
+}
+
+// ReadPart reads a small multipart part, refusing parts larger than limit
+func ReadPart(part io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(part, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("part exceeds %d bytes", limit)
+	}
+	return data, nil
+}
+
+// uploadRequestError is a problem with the request that should be reported to the client as-is
+type uploadRequestError struct {
+	message string
+	err     error
+}
+
+func (e *uploadRequestError) Error() string {
+	return e.message + e.err.Error()
+}
+
+func (e *uploadRequestError) Unwrap() error {
+	return e.err
+}
+
+// uploadValidationError rejects an upload whose rows cannot be reported, with
+// the warnings that explain why
+type uploadValidationError struct {
+	message  string
+	warnings []ParseWarning
+}
+
+func (e *uploadValidationError) Error() string {
+	return e.message
+}
+
+// requestError prefixes err with a user-facing message
+func requestError(message string, err error) error {
+	return &uploadRequestError{message: message, err: err}
+}
+
+// UploadError writes err to the client after message, using 413 when the body
+// exceeded the upload limit and 422, with the warnings, when rows could not be
+// reported
+func UploadError(w http.ResponseWriter, message string, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("Upload exceeds the %d MB limit", tooLarge.Limit>>20), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var invalid *uploadValidationError
+	if errors.As(err, &invalid) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":    message + invalid.message,
+			"warnings": invalid.warnings,
+		})
+		return
+	}
+
+	var reqErr *uploadRequestError
+	if errors.As(err, &reqErr) {
+		http.Error(w, message+reqErr.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, message+err.Error(), http.StatusBadRequest)
+}
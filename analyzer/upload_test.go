@@ -0,0 +1,50 @@
+package analyzer
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUploadError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "request error keeps both prefixes",
+			err:        requestError("Invalid layout: ", errors.New("dataRow must be a positive number")),
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "Failed to read current file: Invalid layout: dataRow must be a positive number",
+		},
+		{
+			name:       "other errors",
+			err:        errors.New("unexpected EOF"),
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "Failed to read current file: unexpected EOF",
+		},
+		{
+			name:       "validation error",
+			err:        &uploadValidationError{message: "2 rows have no FX rate", warnings: []ParseWarning{{Row: 2}}},
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   `"error":"Failed to read current file: 2 rows have no FX rate"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			UploadError(w, "Failed to read current file: ", tt.err)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if body := w.Body.String(); !strings.Contains(body, tt.wantBody) {
+				t.Errorf("body = %q, want it to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+}
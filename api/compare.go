@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"netsuite-pl-analyzer/analyzer"
+)
+
+// CompareHandler compares two uploads, "current" and "prior", of either
+// transaction detail or quarterly income statements
+func CompareHandler(w http.ResponseWriter, r *http.Request) {
+	// Enable CORS
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Both uploads are streamed, each aggregated as it arrives
+	r.Body = http.MaxBytesReader(w, r.Body, analyzer.MaxUploadBytes())
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Failed to parse form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Options may come from the query string or from form fields sent before the files
+	form := r.URL.Query()
+	var mapping *analyzer.AccountMapping
+	var departments *analyzer.DepartmentConfig
+	var rates *analyzer.FXTable
+	mappingUploaded, departmentsUploaded, defaultsLoaded := false, false, false
+	uploads := make(map[string]*analyzer.ComparedUpload)
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			analyzer.UploadError(w, "Failed to parse form: ", err)
+			return
+		}
+
+		name := part.FormName()
+		switch name {
+		case "current", "prior":
+			if uploads[name] != nil {
+				part.Close()
+				http.Error(w, fmt.Sprintf("Only one %q file can be compared", name), http.StatusBadRequest)
+				return
+			}
+			// Uploaded configs win over the server-side defaults
+			if !defaultsLoaded {
+				if !mappingUploaded {
+					if mapping, err = analyzer.LoadDefaultAccountMapping(); err != nil {
+						http.Error(w, "Failed to load account mapping: "+err.Error(), http.StatusInternalServerError)
+						return
+					}
+				}
+				if !departmentsUploaded {
+					if departments, err = analyzer.LoadDefaultDepartmentConfig(); err != nil {
+						http.Error(w, "Failed to load department config: "+err.Error(), http.StatusInternalServerError)
+						return
+					}
+				}
+				defaultsLoaded = true
+			}
+			upload, err := analyzer.CompareUpload(part, part.FileName(), form, mapping, departments, rates)
+			if err != nil {
+				analyzer.UploadError(w, fmt.Sprintf("Failed to read %s file: ", name), err)
+				return
+			}
+			uploads[name] = upload
+		case "mapping", "departments", "fxRates":
+			if len(uploads) > 0 {
+				part.Close()
+				http.Error(w, fmt.Sprintf("Form field %q must be sent before the files", name), http.StatusBadRequest)
+				return
+			}
+			data, err := analyzer.ReadPart(part, analyzer.MaxMappingBytes)
+			if err == nil && name == "mapping" {
+				mapping, err = analyzer.ParseAccountMapping(data)
+				mappingUploaded = true
+			} else if err == nil && name == "departments" {
+				departments, err = analyzer.ParseDepartmentConfig(data)
+				departmentsUploaded = true
+			} else if err == nil {
+				rates, err = analyzer.ParseFXRates(data, part.FileName())
+			}
+			if err != nil {
+				analyzer.UploadError(w, fmt.Sprintf("Failed to load %s: ", name), err)
+				return
+			}
+		default:
+			if len(uploads) > 0 {
+				part.Close()
+				http.Error(w, fmt.Sprintf("Form field %q must be sent before the files", name), http.StatusBadRequest)
+				return
+			}
+			value, err := analyzer.ReadPart(part, analyzer.MaxFieldBytes)
+			if err != nil {
+				analyzer.UploadError(w, "Failed to parse form: ", err)
+				return
+			}
+			form.Add(name, string(value))
+		}
+		part.Close()
+	}
+
+	for _, name := range []string{"current", "prior"} {
+		if uploads[name] == nil {
+			http.Error(w, fmt.Sprintf("Failed to get file: no file part named %q", name), http.StatusBadRequest)
+			return
+		}
+	}
+
+	comparison := &analyzer.Comparison{
+		Type:         compareType(form),
+		CurrentLabel: labelOr(form.Get("currentLabel"), "Current"),
+		PriorLabel:   labelOr(form.Get("priorLabel"), "Prior"),
+	}
+	current, prior := uploads["current"], uploads["prior"]
+	if comparison.Type == analyzer.CompareTypeQuarterly {
+		comparison.Lines, comparison.Departments = analyzer.CompareQuarterlyReports(current.Quarterly, prior.Quarterly, mapping)
+	} else {
+		comparison.Lines = analyzer.ComparePLReports(current.PL, prior.PL)
+	}
+
+	// Return JSON
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(comparison)
+}
+
+// compareType reads the "type" field, defaulting to transaction detail
+func compareType(form url.Values) string {
+	if strings.EqualFold(strings.TrimSpace(form.Get("type")), analyzer.CompareTypeQuarterly) {
+		return analyzer.CompareTypeQuarterly
+	}
+	return analyzer.CompareTypeTransaction
+}
+
+// labelOr returns the trimmed label, or fallback when it is empty
+func labelOr(label, fallback string) string {
+	if label = strings.TrimSpace(label); label != "" {
+		return label
+	}
+	return fallback
+}
@@ -3,7 +3,9 @@ module netsuite-pl-analyzer
 go 1.21
 
 require (
+	github.com/jung-kurt/gofpdf v1.16.2
 	github.com/xuri/excelize/v2 v2.8.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
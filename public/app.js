@@ -1,5 +1,6 @@
 let currentReport = null;
 let reportType = 'transaction'; // Default to transaction detail
+let currencyPrefix = '$'; // Symbol of the report's reporting currency
 
 // Initialize drag and drop
 document.addEventListener('DOMContentLoaded', function() {
@@ -146,6 +147,7 @@ async function uploadFile() {
 
         const report = await response.json();
         currentReport = report;
+        currencyPrefix = currencyPrefixFor(report.currency);
         
         // Display results based on report type
         if (reportType === 'quarterly') {
@@ -172,6 +174,10 @@ function displayResults(report) {
     summaryCards.innerHTML = '';
     categoriesContainer.innerHTML = '';
 
+    // The formatted workbook is built by the server for transaction reports
+    const exportExcelBtn = document.getElementById('exportExcelBtn');
+    if (exportExcelBtn) exportExcelBtn.style.display = 'inline-block';
+
     // Summary cards
     const summaries = [
         { label: 'Revenue', value: report.revenue, type: 'positive' },
@@ -204,9 +210,110 @@ function displayResults(report) {
         }
     });
 
+    // Reconciliation and unclassified transactions
+    if (report.reconciliation) {
+        categoriesContainer.appendChild(createReconciliationSection(report));
+    }
+
+    // Surface row-level parse problems
+    if (report.warnings && report.warnings.length > 0) {
+        const first = report.warnings[0];
+        showError(`${report.warnings.length} row(s) had problems. First: row ${first.row}, ${first.column} "${first.value}": ${first.message}`);
+    }
+
     results.classList.add('active');
 }
 
+function createReconciliationSection(report) {
+    const recon = report.reconciliation;
+    const section = document.createElement('div');
+    section.className = 'category-section';
+
+    const header = document.createElement('div');
+    header.className = 'category-header';
+    header.innerHTML = `
+        <h2>Reconciliation</h2>
+        <div style="font-size: 1.3rem; font-weight: bold; color: ${recon.difference === 0 ? '#667eea' : '#e53e3e'};">
+            ${formatCurrency(recon.difference)}
+        </div>
+    `;
+    section.appendChild(header);
+
+    const stats = document.createElement('div');
+    stats.className = 'category-stats';
+    stats.innerHTML = `
+        <div class="stat-item">
+            <div class="stat-label">Input Total (${recon.inputCount})</div>
+            <div class="stat-value">${formatCurrency(recon.inputTotal)}</div>
+        </div>
+        <div class="stat-item">
+            <div class="stat-label">Classified (${recon.classifiedCount})</div>
+            <div class="stat-value">${formatCurrency(recon.classifiedTotal)}</div>
+        </div>
+        <div class="stat-item">
+            <div class="stat-label">Unclassified (${recon.unclassifiedCount})</div>
+            <div class="stat-value">${formatCurrency(recon.unclassifiedTotal)}</div>
+        </div>
+    `;
+    // Intercompany eliminations are part of the difference in consolidated reports
+    if (recon.eliminatedCount) {
+        stats.innerHTML += `
+        <div class="stat-item">
+            <div class="stat-label">Eliminated (${recon.eliminatedCount})</div>
+            <div class="stat-value">${formatCurrency(recon.eliminatedTotal)}</div>
+        </div>
+    `;
+    }
+    section.appendChild(stats);
+
+    if (report.unclassified && report.unclassified.length > 0) {
+        const table = document.createElement('div');
+        table.className = 'subcategories';
+
+        const headerRow = document.createElement('div');
+        headerRow.className = 'subcategory-row';
+        headerRow.style.background = '#f0f0f0';
+        headerRow.style.fontWeight = 'bold';
+        headerRow.innerHTML = `
+            <div>Document</div>
+            <div>Account</div>
+            <div>Department</div>
+            <div class="subcategory-value">Amount</div>
+        `;
+        table.appendChild(headerRow);
+
+        report.unclassified.forEach(trans => {
+            const row = document.createElement('div');
+            row.className = 'subcategory-row';
+            // Cells come straight from the uploaded file, so they are set as text
+            const cells = [
+                ['subcategory-name', trans.docNumber],
+                ['', trans.account],
+                ['', trans.department],
+                ['subcategory-value', formatCurrency(trans.amount)]
+            ];
+            cells.forEach(([className, text]) => {
+                const cell = document.createElement('div');
+                if (className) cell.className = className;
+                cell.textContent = text || '';
+                row.appendChild(cell);
+            });
+            table.appendChild(row);
+        });
+
+        if (report.unclassifiedOmitted) {
+            const more = document.createElement('div');
+            more.className = 'subcategory-row';
+            more.innerHTML = `<div class="subcategory-name">…and ${report.unclassifiedOmitted} more (included in the totals above)</div>`;
+            table.appendChild(more);
+        }
+
+        section.appendChild(table);
+    }
+
+    return section;
+}
+
 function createCategorySection(name, category) {
     const section = document.createElement('div');
     section.className = 'category-section';
@@ -290,14 +397,21 @@ function formatCurrency(value, isPercent = false) {
     const sign = value < 0 ? '-' : '';
     
     if (absValue >= 1000000) {
-        return sign + '$' + (absValue / 1000000).toFixed(2) + 'M';
+        return sign + currencyPrefix + (absValue / 1000000).toFixed(2) + 'M';
     } else if (absValue >= 1000) {
-        return sign + '$' + (absValue / 1000).toFixed(1) + 'K';
+        return sign + currencyPrefix + (absValue / 1000).toFixed(1) + 'K';
     } else {
-        return sign + '$' + absValue.toFixed(2);
+        return sign + currencyPrefix + absValue.toFixed(2);
     }
 }
 
+// Reports without a currency are in US dollars
+function currencyPrefixFor(currency) {
+    const symbols = { USD: '$', EUR: '€', GBP: '£', JPY: '¥' };
+    if (!currency) return '$';
+    return symbols[currency] || currency + ' ';
+}
+
 function showError(message) {
     const errorDiv = document.getElementById('errorMessage');
     errorDiv.textContent = message;
@@ -328,19 +442,11 @@ function exportToCSV() {
             const dept = currentReport.departments[deptName];
             
             rows.push([deptName, formatCurrencyExport(dept.total)]);
-            rows.push(['Line Item', 'Amount']);
+            rows.push(['Row', 'Line Item', 'Amount']);
             
-            // Sort line items by account number
-            const sortedItems = Object.entries(dept.lineItems).sort((a, b) => {
-                const getAccountNum = (item) => {
-                    const match = item.match(/^(\d+)/);
-                    return match ? parseInt(match[1]) : 99999;
-                };
-                return getAccountNum(a[0]) - getAccountNum(b[0]);
-            });
-            
-            sortedItems.forEach(([lineItem, amount]) => {
-                rows.push([lineItem, formatCurrencyExport(amount)]);
+            // Line items are already in statement order
+            dept.lineItems.forEach(item => {
+                rows.push([item.row, item.label, formatCurrencyExport(item.amount)]);
             });
             
             rows.push([]);
@@ -377,6 +483,38 @@ function exportToCSV() {
                 rows.push([]);
             }
         });
+
+        // Reconciliation
+        const recon = currentReport.reconciliation;
+        if (recon) {
+            rows.push(['Reconciliation']);
+            rows.push(['Metric', 'Count', 'Amount']);
+            rows.push(['Input Total', recon.inputCount, formatCurrencyExport(recon.inputTotal)]);
+            rows.push(['Classified Total', recon.classifiedCount, formatCurrencyExport(recon.classifiedTotal)]);
+            rows.push(['Unclassified Total', recon.unclassifiedCount, formatCurrencyExport(recon.unclassifiedTotal)]);
+            if (recon.eliminatedCount) {
+                rows.push(['Eliminated Total', recon.eliminatedCount, formatCurrencyExport(recon.eliminatedTotal)]);
+            }
+            rows.push(['Difference', '', formatCurrencyExport(recon.difference)]);
+            rows.push([]);
+        }
+
+        if (currentReport.unclassified && currentReport.unclassified.length > 0) {
+            rows.push(['Unclassified Transactions']);
+            rows.push(['Date', 'Document Number', 'Name', 'Account', 'Department', 'Class', 'Amount']);
+            currentReport.unclassified.forEach(trans => {
+                rows.push([
+                    trans.date,
+                    trans.docNumber,
+                    trans.name,
+                    trans.account,
+                    trans.department,
+                    trans.class,
+                    formatCurrencyExport(trans.amount)
+                ]);
+            });
+            rows.push([]);
+        }
     }
 
     // Convert to CSV
@@ -427,8 +565,57 @@ function addCategoryRows(rows, categoryName, category) {
     }
 }
 
+// Export the P&L as a formatted Excel workbook built by the server
+function exportToExcel() {
+    downloadExport('/api/analyze?format=xlsx', 'pl-report-', '.xlsx');
+}
+
+// Export the report as a PDF board pack built by the server
+function exportToPDF() {
+    if (reportType === 'quarterly') {
+        // Include the HC split when it is on screen
+        const mode = window.currentHCAnalysis ? '&mode=hc' : '';
+        downloadExport('/api/quarterly?format=pdf' + mode, 'quarterly-income-statement-', '.pdf');
+    } else {
+        downloadExport('/api/analyze?format=pdf', 'pl-report-', '.pdf');
+    }
+}
+
+// Re-send the selected file to an endpoint and download the response
+async function downloadExport(endpoint, filename, extension) {
+    const file = window.selectedFile;
+    if (!file) return;
+
+    try {
+        const formData = new FormData();
+        formData.append('file', file);
+
+        const response = await fetch(endpoint, {
+            method: 'POST',
+            body: formData
+        });
+
+        if (!response.ok) {
+            const errorText = await response.text();
+            throw new Error(errorText || 'Failed to export report');
+        }
+
+        const blob = await response.blob();
+        const url = window.URL.createObjectURL(blob);
+        const a = document.createElement('a');
+        a.href = url;
+        a.download = filename + new Date().toISOString().split('T')[0] + extension;
+        a.click();
+        window.URL.revokeObjectURL(url);
+    } catch (error) {
+        console.error('Error:', error);
+        showError('Failed to export report: ' + error.message);
+    }
+}
+
 function formatCurrencyExport(value) {
-    return '$' + value.toFixed(2);
+    const sign = value < 0 ? '-' : '';
+    return sign + currencyPrefix + Math.abs(value).toFixed(2);
 }
 
 // Analyze HC vs Non-HC for quarterly income statements
@@ -453,7 +640,7 @@ async function analyzeHCvsNonHC() {
         const formData = new FormData();
         formData.append('file', file);
 
-        const response = await fetch('/api/quarterly', {
+        const response = await fetch('/api/quarterly?mode=hc', {
             method: 'POST',
             body: formData
         });
@@ -501,103 +688,14 @@ function displayHCAnalysis(report) {
         `;
     }
 
-    // Normalize department names and combine duplicates
-    const normalizedDepts = {};
-    Object.keys(report.departments).forEach(deptName => {
-        // Normalize name: capitalize first letter of each word
-        const normalized = deptName.split(' ')
-            .map(word => word.charAt(0).toUpperCase() + word.slice(1).toLowerCase())
-            .join(' ')
-            .replace(/&/g, '&'); // Ensure & is consistent
-        
-        if (!normalizedDepts[normalized]) {
-            normalizedDepts[normalized] = {
-                department: normalized,
-                lineItems: {},
-                total: 0
-            };
-        }
-        
-        // Merge line items
-        const dept = report.departments[deptName];
-        Object.entries(dept.lineItems).forEach(([lineItem, amount]) => {
-            if (normalizedDepts[normalized].lineItems[lineItem]) {
-                normalizedDepts[normalized].lineItems[lineItem] += amount;
-            } else {
-                normalizedDepts[normalized].lineItems[lineItem] = amount;
-            }
-        });
-    });
-    
-    // Analyze each department
+    // The HC split is computed by the server (mode=hc), which also merges
+    // department names that differ only in spelling
     const hcAnalysis = {};
-    Object.keys(normalizedDepts).forEach(deptName => {
-        const dept = normalizedDepts[deptName];
-        
-        let hcTotal = 0;
-        let totalExpenses = 0;
-        let foundTotalExpenseLine = false;
-        
-        // First, try to find the main expense total line
-        // Look for lines like "Total - 60000 - Operating expenses" or the largest expense total
-        let largestExpenseTotal = 0;
-        Object.entries(dept.lineItems).forEach(([lineItem, amount]) => {
-            const lineItemLower = lineItem.toLowerCase().trim();
-            
-            // Look for "Total - 60000" or "Total - Operating expenses"
-            if ((lineItemLower.startsWith('total - 6') && lineItemLower.includes('operating')) ||
-                (lineItemLower.includes('total') && lineItemLower.includes('60000'))) {
-                if (Math.abs(amount) > Math.abs(largestExpenseTotal)) {
-                    totalExpenses = amount;
-                    largestExpenseTotal = amount;
-                    foundTotalExpenseLine = true;
-                }
-            }
-        });
-        
-        // If we didn't find a Total Expense line, calculate it manually
-        if (!foundTotalExpenseLine) {
-            Object.entries(dept.lineItems).forEach(([lineItem, amount]) => {
-                const lineItemLower = lineItem.toLowerCase().trim();
-                
-                // Skip revenue, net income, and summary lines
-                if (lineItemLower.includes('revenue') || 
-                    lineItemLower.includes('net income') || 
-                    lineItemLower.includes('net loss') ||
-                    lineItemLower.includes('net ordinary') ||
-                    lineItemLower.includes('other income') ||
-                    lineItemLower.startsWith('total ')) {
-                    return;
-                }
-                
-                // Check account number - only include expense accounts (60000+)
-                const accountMatch = lineItem.match(/^(\d+)/);
-                const accountNum = accountMatch ? parseInt(accountMatch[1]) : 0;
-                
-                if (accountNum >= 60000 && accountNum < 70000) {
-                    totalExpenses += amount;
-                }
-            });
-        }
-        
-        // Calculate HC (61000 series)
-        Object.entries(dept.lineItems).forEach(([lineItem, amount]) => {
-            const accountMatch = lineItem.match(/^(\d+)/);
-            const accountNum = accountMatch ? parseInt(accountMatch[1]) : 0;
-            
-            // 61000-61999 are HC (compensation)
-            if (accountNum >= 61000 && accountNum < 62000) {
-                hcTotal += amount;
-            }
-        });
-        
-        // Non-HC = Total Expenses - HC
-        const nonHcTotal = totalExpenses - hcTotal;
-        
-        hcAnalysis[deptName] = {
-            hc: hcTotal,
-            nonHc: nonHcTotal,
-            total: totalExpenses
+    report.hc.departments.forEach(dept => {
+        hcAnalysis[dept.department] = {
+            hc: dept.headcount,
+            nonHc: dept.nonHeadcount,
+            total: dept.total
         };
     });
 
@@ -638,7 +736,7 @@ function displayHCAnalysis(report) {
     headerRow.style.fontWeight = 'bold';
     headerRow.innerHTML = `
         <div>Department</div>
-        <div class="subcategory-value">HC (61000 series)</div>
+        <div class="subcategory-value">HC</div>
         <div class="subcategory-value">Non-HC</div>
         <div class="subcategory-value">Total</div>
     `;
@@ -753,7 +851,7 @@ function displayQuarterlyResults(report) {
         section.appendChild(header);
 
         // Line items table
-        if (dept.lineItems && Object.keys(dept.lineItems).length > 0) {
+        if (dept.lineItems && dept.lineItems.length > 0) {
             const table = document.createElement('div');
             table.className = 'subcategories';
 
@@ -768,18 +866,8 @@ function displayQuarterlyResults(report) {
             `;
             table.appendChild(headerRow);
 
-            // Sort line items by account number (ascending)
-            const sortedItems = Object.entries(dept.lineItems).sort((a, b) => {
-                // Extract account numbers from line items (e.g., "40000" from "40000 - Revenue")
-                const getAccountNum = (item) => {
-                    const match = item.match(/^(\d+)/);
-                    return match ? parseInt(match[1]) : 99999;
-                };
-                return getAccountNum(a[0]) - getAccountNum(b[0]);
-            });
-
-            // Add line item rows
-            sortedItems.forEach(([lineItem, amount]) => {
+            // Add line item rows in statement order
+            dept.lineItems.forEach(({ label: lineItem, amount }) => {
                 if (amount !== 0) {
                     const row = document.createElement('div');
                     row.className = 'subcategory-row';
@@ -825,7 +913,7 @@ window.exportHCAnalysisToCSV = function() {
     rows.push([]);
     
     // Summary table
-    rows.push(['Department', 'HC (61000 series)', 'Non-HC', 'Total']);
+    rows.push(['Department', 'HC', 'Non-HC', 'Total']);
     
     Object.keys(window.currentHCAnalysis).forEach(deptName => {
         const analysis = window.currentHCAnalysis[deptName];